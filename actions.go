@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// What can be done to a post, the same from the window and the terminal.
+// Errors say what couldn't be done, for the frontend to show
+
+// The post the actions are for. A retweet's own ID can't be favourited,
+// boosted or replied to, that's for the tweet it shows
+func actionTarget(t *TweetInfo) Post {
+	return Post{ID: t.ShownID, Author: User{ScreenName: t.ScreenName}}
+}
+
+func favouritePost(backend Backend, p Post) error {
+	if err := backend.Favourite(p.ID); err != nil {
+		return fmt.Errorf("Could not favourite: %v", err)
+	}
+	return nil
+}
+
+func boostPost(backend Backend, p Post) error {
+	if err := backend.Boost(p.ID); err != nil {
+		return fmt.Errorf("Could not boost: %v", err)
+	}
+	return nil
+}
+
+func replyToPost(backend Backend, to Post, text string) error {
+	if _, err := backend.Reply(&to, text); err != nil {
+		return fmt.Errorf("Could not reply: %v", err)
+	}
+	return nil
+}
+
+// Hides the posts of screenName. The filters only apply once stored with
+// applyFilters, the window does that from its event loop
+func muteUser(f *Filters, screenName string) {
+	addFilterRule(&f.MutedUsers, screenName)
+}
+
+// Stores edited filters and reloads the columns with them. A column that
+// can't be reloaded doesn't stop the others
+func applyFilters(R Renderer, DB *bolt.DB, f *Filters, columns []*Column) error {
+	if err := saveFilters(DB, f); err != nil {
+		return fmt.Errorf("Could not save filters: %v", err)
+	}
+	var Result error
+	for _, c := range columns {
+		if err := ReloadColumn(R, DB, f, c); err != nil {
+			Result = fmt.Errorf("Could not reload %s: %v", timelineTitle(c.Source), err)
+		}
+	}
+	return Result
+}
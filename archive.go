@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// How posts look in exports. Unlike the stored Post, the names are meant to be read
+type exportedPost struct {
+	ID             string    `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ScreenName     string    `json:"screen_name"`
+	Name           string    `json:"name"`
+	Text           string    `json:"text"`
+	InReplyTo      string    `json:"in_reply_to,omitempty"`
+	RetweetOf      string    `json:"retweet_of,omitempty"`
+	Favourited     bool      `json:"favourited"`
+	FavouriteCount int       `json:"favourite_count"`
+	RetweetCount   int       `json:"retweet_count"`
+	Hashtags       []string  `json:"hashtags,omitempty"`
+	Mentions       []string  `json:"mentions,omitempty"`
+	AvatarURL      string    `json:"avatar_url"`
+}
+
+func exportPost(p *Post) exportedPost {
+	shown := shownPost(p)
+	Result := exportedPost{
+		ID:             strconv.FormatInt(p.ID, 10),
+		CreatedAt:      p.CreatedAt,
+		ScreenName:     shown.Author.ScreenName,
+		Name:           shown.Author.Name,
+		Text:           shown.Text,
+		Favourited:     p.Favourited,
+		FavouriteCount: shown.FavouriteCount,
+		RetweetCount:   shown.ReblogCount,
+		Hashtags:       postEntities(shown, HashtagEntity),
+		Mentions:       postEntities(shown, MentionEntity),
+		AvatarURL:      shown.Author.AvatarURL,
+	}
+	if shown.InReplyTo != 0 {
+		Result.InReplyTo = strconv.FormatInt(shown.InReplyTo, 10)
+	}
+	if p.Reblog != nil {
+		Result.RetweetOf = strconv.FormatInt(p.Reblog.ID, 10)
+	}
+	return Result
+}
+
+// Every stored post, oldest first
+func allPosts(DB *bolt.DB) ([]Post, error) {
+	var Result []Post
+	err := DB.View(func(Tx *bolt.Tx) error {
+		return Tx.Bucket([]byte("tweets")).ForEach(func(k, v []byte) error {
+			p, err := decodePost(v)
+			if err != nil {
+				return err
+			}
+			Result = append(Result, *p)
+			return nil
+		})
+	})
+	// Keys are hex of varying length, so they don't sort by ID
+	sort.Slice(Result, func(i, j int) bool { return Result[i].ID < Result[j].ID })
+	return Result, err
+}
+
+func exportJSONLines(w io.Writer, posts []Post) error {
+	encoder := json.NewEncoder(w)
+	for i := range posts {
+		if err := encoder.Encode(exportPost(&posts[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportCSV(w io.Writer, posts []Post) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "created_at", "screen_name", "name", "text", "in_reply_to", "retweet_of", "favourite_count", "retweet_count"})
+	for i := range posts {
+		e := exportPost(&posts[i])
+		writer.Write([]string{e.ID, e.CreatedAt.Format(time.RFC3339), e.ScreenName, e.Name, e.Text, e.InReplyTo, e.RetweetOf,
+			strconv.Itoa(e.FavouriteCount), strconv.Itoa(e.RetweetCount)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+var archiveTemplate = template.Must(template.New("archive").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: auto; background: #222; color: #DDD; }
+.post { display: flex; padding: 0.5em; border-bottom: 1px solid #444; }
+.post img { width: 48px; height: 48px; margin-right: 0.5em; }
+.text { white-space: pre-wrap; }
+.meta { color: #777; font-size: small; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Posts}}<div class="post" id="{{.ID}}">
+{{if .Avatar}}<img src="{{.Avatar}}" alt="">{{end}}
+<div>
+<b>{{.Post.Name}}</b> <span class="meta">@{{.Post.ScreenName}} · {{.Post.CreatedAt.Format "2006-01-02 15:04"}}{{if .Post.RetweetOf}} · retweet{{end}}{{if .Post.InReplyTo}} · <a href="#{{.Post.InReplyTo}}">in reply</a>{{end}}</span>
+<div class="text">{{.Post.Text}}</div>
+</div>
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+type archivedPost struct {
+	ID     string
+	Post   exportedPost
+	Avatar string // empty if there's no avatar to show
+}
+
+func writeArchiveHTML(w io.Writer, title string, posts []archivedPost) error {
+	return archiveTemplate.Execute(w, struct {
+		Title string
+		Posts []archivedPost
+	}{title, posts})
+}
+
+// Writes index.html into dir, with the avatars found in the image cache
+// copied next to it so the archive works offline
+func exportHTML(dir string, title string, posts []Post) error {
+	if err := os.MkdirAll(filepath.Join(dir, "avatars"), 0755); err != nil {
+		return err
+	}
+	var archived []archivedPost
+	copied := make(map[string]string)
+	for i := len(posts) - 1; i >= 0; i-- {
+		e := exportPost(&posts[i])
+		avatar, ok := copied[e.AvatarURL]
+		if !ok && e.AvatarURL != "" {
+			cached := URLToFilename(e.AvatarURL)
+			data, err := ioutil.ReadFile(cached)
+			if err == nil {
+				avatar = "avatars/" + filepath.Base(cached)
+				if err := ioutil.WriteFile(filepath.Join(dir, avatar), data, 0644); err != nil {
+					return err
+				}
+			}
+			copied[e.AvatarURL] = avatar
+		}
+		archived = append(archived, archivedPost{e.ID, e, avatar})
+	}
+
+	var buf bytes.Buffer
+	if err := writeArchiveHTML(&buf, title, archived); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "index.html"), buf.Bytes(), 0644)
+}
+
+// A tweet in data/tweets.js of the archive twitter.com lets users download.
+// Numbers come as strings there
+type archivedTweet struct {
+	IDStr                string `json:"id_str"`
+	FullText             string `json:"full_text"`
+	CreatedAt            string `json:"created_at"`
+	InReplyToStatusIDStr string `json:"in_reply_to_status_id_str"`
+	InReplyToUserIDStr   string `json:"in_reply_to_user_id_str"`
+	FavoriteCount        string `json:"favorite_count"`
+	RetweetCount         string `json:"retweet_count"`
+	Favorited            bool   `json:"favorited"`
+	Retweeted            bool   `json:"retweeted"`
+	Entities             struct {
+		Hashtags []struct {
+			Text string `json:"text"`
+		} `json:"hashtags"`
+		UserMentions []struct {
+			ScreenName string `json:"screen_name"`
+		} `json:"user_mentions"`
+		URLs []struct {
+			URL         string `json:"url"`
+			ExpandedURL string `json:"expanded_url"`
+		} `json:"urls"`
+	} `json:"entities"`
+}
+
+func postFromArchive(t *archivedTweet, author User) Post {
+	ID, _ := strconv.ParseInt(t.IDStr, 10, 64)
+	createdAt, _ := parseTwitterTime(t.CreatedAt)
+	p := Post{
+		ID:         ID,
+		Text:       t.FullText,
+		CreatedAt:  createdAt,
+		Author:     author,
+		Favourited: t.Favorited,
+		Reblogged:  t.Retweeted,
+	}
+	p.InReplyTo, _ = strconv.ParseInt(t.InReplyToStatusIDStr, 10, 64)
+	p.InReplyToUser, _ = strconv.ParseInt(t.InReplyToUserIDStr, 10, 64)
+	p.FavouriteCount, _ = strconv.Atoi(t.FavoriteCount)
+	p.ReblogCount, _ = strconv.Atoi(t.RetweetCount)
+	for _, h := range t.Entities.Hashtags {
+		p.Entities = append(p.Entities, Entity{Kind: HashtagEntity, Text: h.Text})
+	}
+	for _, m := range t.Entities.UserMentions {
+		p.Entities = append(p.Entities, Entity{Kind: MentionEntity, Text: m.ScreenName})
+	}
+	for _, u := range t.Entities.URLs {
+		p.Entities = append(p.Entities, Entity{Kind: URLEntity, Text: u.URL, URL: u.ExpandedURL})
+	}
+	return p
+}
+
+// Stores the tweets of a twitter archive as posts of the account, in its user
+// timeline. Tweets already stored are left alone, as fetched ones have more
+// than the archive: avatars, names and retweeted posts. Returns how many were
+// imported
+func importTwitterArchive(DB *bolt.DB, a *Account, path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// The file is javascript: "window.YTD.tweets.part0 = [ ... ]"
+	start := bytes.IndexByte(data, '[')
+	if start < 0 {
+		return 0, errors.New(path + " doesn't look like a tweets.js file")
+	}
+	var entries []struct {
+		Tweet archivedTweet `json:"tweet"`
+	}
+	if err := json.Unmarshal(data[start:], &entries); err != nil {
+		return 0, fmt.Errorf("Could not read %s: %v", path, err)
+	}
+
+	// Archives don't repeat who wrote the tweets, it's whoever downloaded them
+	author := User{ScreenName: a.ScreenName, Name: a.ScreenName}
+	author.ID, _ = strconv.ParseInt(a.UserID, 10, 64)
+	timeline := timelineKey(TimelineSource{Kind: "user", Arg: a.ScreenName})
+
+	imported := 0
+	err = DB.Update(func(Tx *bolt.Tx) error {
+		for i := range entries {
+			p := postFromArchive(&entries[i].Tweet, author)
+			if p.ID == 0 {
+				continue
+			}
+			if err := addToTimeline(Tx, timeline, p.ID); err != nil {
+				return err
+			}
+			if Tx.Bucket([]byte("tweets")).Get(tweetKey(p.ID)) != nil {
+				continue
+			}
+			if err := storePost(Tx, &p); err != nil {
+				return err
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// gowitt export jsonl|csv [file], gowitt export html <directory>
+func exportCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: gowitt export jsonl|csv [file] or gowitt export html <directory>")
+	}
+	DB, a, err := openCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+	posts, err := allPosts(DB)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "html" {
+		if len(args) < 2 {
+			return errors.New("Usage: gowitt export html <directory>")
+		}
+		return exportHTML(args[1], "@"+a.ScreenName, posts)
+	}
+	var w io.Writer = os.Stdout
+	if len(args) > 1 {
+		file, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	switch args[0] {
+	case "jsonl":
+		return exportJSONLines(w, posts)
+	case "csv":
+		return exportCSV(w, posts)
+	}
+	return errors.New("Unknown export format " + args[0])
+}
+
+// gowitt import <tweets.js>
+func importCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: gowitt import <tweets.js from a twitter archive>")
+	}
+	DB, a, err := openCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+	imported, err := importTwitterArchive(DB, a, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println("Imported", imported, "tweets into @"+a.ScreenName)
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportTwitterArchive(t *testing.T) {
+	DB, err := initDB(filepath.Join(t.TempDir(), "tweets.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DB.Close()
+	a := &Account{ScreenName: "bob", UserID: "4242"}
+
+	fetched := Post{
+		ID:             1050300000000000002,
+		Text:           "Already fetched, the archive has less of it",
+		CreatedAt:      time.Date(2018, 10, 11, 8, 0, 0, 0, time.UTC),
+		Author:         User{ID: 4242, Name: "Bob", ScreenName: "bob", AvatarURL: "https://example.com/bob.png"},
+		FavouriteCount: 12,
+	}
+	err = DB.Update(func(Tx *bolt.Tx) error {
+		return storePost(Tx, &fetched)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := importTwitterArchive(DB, a, filepath.Join("testdata", "tweets.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Errorf("imported %d tweets, want 2", imported)
+	}
+	if IDs := timelineIDs(t, DB, "user:bob"); !reflect.DeepEqual(IDs, []int64{1050300000000000002, 1050200000000000001, 1050118621198921728}) {
+		t.Errorf("user timeline is %v", IDs)
+	}
+
+	if p, err := getPost(DB, fetched.ID); err != nil || p.FavouriteCount != 12 || p.Author != fetched.Author {
+		t.Errorf("import replaced a fetched post with %+v, %v", p, err)
+	}
+	p, err := getPost(DB, 1050118621198921728)
+	if err != nil || p == nil {
+		t.Fatalf("first tweet is %v, %v", p, err)
+	}
+	if p.Author.ID != 4242 || p.Author.ScreenName != "bob" || p.FavouriteCount != 3 || p.ReblogCount != 1 ||
+		!p.CreatedAt.Equal(time.Date(2018, 10, 10, 20, 19, 24, 0, time.UTC)) {
+		t.Errorf("first tweet is %+v", p)
+	}
+	if tags, links := postEntities(p, HashtagEntity), p.Entities[len(p.Entities)-1]; !reflect.DeepEqual(tags, []string{"gowitt"}) ||
+		links.URL != "https://example.com/release" {
+		t.Errorf("first tweet has entities %+v", p.Entities)
+	}
+	if replies, err := getReplyIDs(DB, 1050199999999999999); err != nil || !reflect.DeepEqual(replies, []int64{1050200000000000001}) {
+		t.Errorf("replies in the archive are %v, %v", replies, err)
+	}
+	p, err = getPost(DB, 1050200000000000001)
+	if err != nil || p == nil || !p.Favourited || p.InReplyToUser != 783214 {
+		t.Errorf("reply is %+v, %v", p, err)
+	}
+
+	// Importing again finds everything stored
+	if imported, err := importTwitterArchive(DB, a, filepath.Join("testdata", "tweets.js")); err != nil || imported != 0 {
+		t.Errorf("importing again imported %d, %v", imported, err)
+	}
+}
+
+func exportTestPosts() []Post {
+	ann := User{ID: 7, Name: "Ann, \"the\" tester", ScreenName: "ann", AvatarURL: "https://example.com/ann.png"}
+	original := Post{ID: 100, Text: "Original\nover two lines", CreatedAt: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC), Author: ann, ReblogCount: 2}
+	return []Post{
+		original,
+		{ID: 101, Text: "<b>not bold</b> & #tagged @bob", CreatedAt: time.Date(2020, 5, 1, 11, 0, 0, 0, time.UTC), Author: ann,
+			InReplyTo: 100, Favourited: true, FavouriteCount: 5,
+			Entities: []Entity{{Kind: HashtagEntity, Text: "tagged"}, {Kind: MentionEntity, Text: "bob"}}},
+		{ID: 102, CreatedAt: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC), Author: User{ID: 8, Name: "Bob", ScreenName: "bob"}, Reblog: &original},
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	posts := exportTestPosts()
+	var want []exportedPost
+	for i := range posts {
+		want = append(want, exportPost(&posts[i]))
+	}
+	if want[2].RetweetOf != "100" || want[2].ScreenName != "ann" || want[1].InReplyTo != "100" {
+		t.Fatalf("exported posts are %+v", want)
+	}
+
+	var buf bytes.Buffer
+	if err := exportJSONLines(&buf, posts); err != nil {
+		t.Fatal(err)
+	}
+	var got []exportedPost
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e exportedPost
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON lines read back as %+v, want %+v", got, want)
+	}
+
+	buf.Reset()
+	if err := exportCSV(&buf, posts); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(posts)+1 {
+		t.Fatalf("CSV has %d rows for %d posts", len(rows), len(posts))
+	}
+	for i, row := range rows[1:] {
+		e := want[i]
+		wantRow := []string{e.ID, e.CreatedAt.Format(time.RFC3339), e.ScreenName, e.Name, e.Text, e.InReplyTo, e.RetweetOf,
+			strconv.Itoa(e.FavouriteCount), strconv.Itoa(e.RetweetCount)}
+		if !reflect.DeepEqual(row, wantRow) {
+			t.Errorf("CSV row %d is %q, want %q", i, row, wantRow)
+		}
+	}
+
+	// Only the avatars in the image cache are copied
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if err := os.MkdirAll(imageCacheDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(URLToFilename("https://example.com/ann.png"), []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := exportHTML(dir, "@bob", posts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	avatar := "avatars/" + filepath.Base(URLToFilename("https://example.com/ann.png"))
+	if copied, err := ioutil.ReadFile(filepath.Join(dir, avatar)); err != nil || string(copied) != "png" {
+		t.Errorf("avatar wasn't copied: %v", err)
+	}
+	for _, e := range want {
+		for _, s := range []string{`id="` + e.ID + `"`, html.EscapeString(e.Text), html.EscapeString(e.Name), `src="` + avatar + `"`} {
+			if !strings.Contains(page, s) {
+				t.Errorf("index.html is missing %s", s)
+			}
+		}
+	}
+	if strings.Contains(page, "<b>not bold</b>") {
+		t.Error("index.html has the markup of a post")
+	}
+	// Newest first
+	if strings.Index(page, `id="102"`) > strings.Index(page, `id="100"`) {
+		t.Error("index.html isn't newest first")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/garyburd/go-oauth/oauth"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const CredentialsFileName = "credentials.json"
+
+type Account struct {
+	Backend    string `json:"backend,omitempty"`  // "twitter" when empty, or "mastodon"
+	Instance   string `json:"instance,omitempty"` // base URL of the mastodon instance
+	ScreenName string `json:"screen_name"`
+	UserID     string `json:"user_id"`
+	Token      string `json:"token"`
+	Secret     string `json:"secret"`
+}
+
+// Stored apart from the config, readable only by the user
+type Credentials struct {
+	Current  string    `json:"current"` // accountKey of the logged in account
+	Accounts []Account `json:"accounts"`
+}
+
+// Tells accounts apart. Screen names can be taken on several backends and
+// instances, and change, so it goes by user ID when there's one
+func accountKey(a *Account) string {
+	backend := "twitter"
+	if a.Backend == "mastodon" {
+		backend = "mastodon"
+	}
+	ID := a.UserID
+	if ID == "" {
+		ID = "@" + a.ScreenName
+	}
+	Result := backend + ":" + ID
+	if a.Instance != "" {
+		Result += "@" + a.Instance
+	}
+	return Result
+}
+
+// Whether a and b are the same account, maybe logged in again since. Accounts
+// stored before they had user IDs can only go by screen name
+func sameAccount(a, b *Account) bool {
+	if (a.Backend == "mastodon") != (b.Backend == "mastodon") || a.Instance != b.Instance {
+		return false
+	}
+	if a.UserID != "" && b.UserID != "" {
+		return a.UserID == b.UserID
+	}
+	return a.ScreenName == b.ScreenName
+}
+
+func credentialsPath() string {
+	return filepath.Join(configDir(), CredentialsFileName)
+}
+
+func loadCredentials() (*Credentials, error) {
+	Result := &Credentials{}
+	data, err := ioutil.ReadFile(credentialsPath())
+	if os.IsNotExist(err) {
+		return Result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Result, json.Unmarshal(data, Result)
+}
+
+func saveCredentials(c *Credentials) error {
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := credentialsPath()
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Returns nil if nobody is logged in
+func currentAccount(c *Credentials) *Account {
+	for i := range c.Accounts {
+		if accountKey(&c.Accounts[i]) == c.Current {
+			return &c.Accounts[i]
+		}
+	}
+	// Older credentials have the screen name
+	for i := range c.Accounts {
+		if c.Accounts[i].ScreenName == c.Current {
+			return &c.Accounts[i]
+		}
+	}
+	return nil
+}
+
+func setConsumerKeys() {
+	anaconda.SetConsumerKey("KmxA5PMS1WaVdSnJrYtq5XANb")
+	anaconda.SetConsumerSecret("yt7ydv2qFt7BpyHrMK3UzIj7HXGGv7ezcVTnELxhgh2WMGj9IA")
+}
+
+func newTwitterApi(a *Account) *anaconda.TwitterApi {
+	setConsumerKeys()
+	return anaconda.NewTwitterApi(a.Token, a.Secret)
+}
+
+// First half of the PIN flow: gets a request token and the URL where the user authorizes it
+func startLogin() (string, *oauth.Credentials, error) {
+	setConsumerKeys()
+	api := anaconda.NewTwitterApi("", "")
+	defer api.Close()
+	return api.AuthorizationURL("oob")
+}
+
+// Second half of the PIN flow: exchanges the request token and PIN for an
+// access token, and stores it as the current account
+func finishLogin(c *Credentials, requestToken *oauth.Credentials, pin string) (*Account, error) {
+	account, err := exchangePIN(requestToken, pin)
+	if err != nil {
+		return nil, err
+	}
+	return addAccount(c, account)
+}
+
+// The part of finishLogin that asks twitter.com
+func exchangePIN(requestToken *oauth.Credentials, pin string) (Account, error) {
+	setConsumerKeys()
+	api := anaconda.NewTwitterApi("", "")
+	defer api.Close()
+	accessToken, values, err := api.GetCredentials(requestToken, strings.TrimSpace(pin))
+	if err != nil {
+		return Account{}, err
+	}
+	account := Account{
+		ScreenName: values.Get("screen_name"),
+		UserID:     values.Get("user_id"),
+		Token:      accessToken.Token,
+		Secret:     accessToken.Secret,
+	}
+	if account.ScreenName == "" {
+		return Account{}, errors.New("Twitter didn't say who logged in")
+	}
+	return account, nil
+}
+
+// Stores a logged in account as the current one
+func addAccount(c *Credentials, account Account) (*Account, error) {
+	// Logging in again replaces the old tokens, and the screen name if it changed
+	replaced := false
+	for i := range c.Accounts {
+		if sameAccount(&c.Accounts[i], &account) {
+			c.Accounts[i] = account
+			replaced = true
+		}
+	}
+	if !replaced {
+		c.Accounts = append(c.Accounts, account)
+	}
+	c.Current = accountKey(&account)
+	if err := saveCredentials(c); err != nil {
+		return nil, err
+	}
+	return currentAccount(c), nil
+}
+
+func openBrowser(URL string) {
+	if err := exec.Command("xdg-open", URL).Start(); err != nil {
+		logWarn("Could not open a browser:", err)
+	}
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func loginInTerminal(c *Credentials) (*Account, error) {
+	authURL, requestToken, err := startLogin()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Authorize gowitt by visiting this page, then enter the PIN it shows:")
+	fmt.Println(authURL)
+	openBrowser(authURL)
+
+	fmt.Print("PIN: ")
+	pin, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return finishLogin(c, requestToken, pin)
+}
+
+// Same as loginInTerminal, but asks for the PIN with a prompt in the window.
+// twitter.com is asked in the background, the credentials are only changed on
+// the event loop. onLogin is called once the new account is stored
+func LoginInWindow(W *XWindow, c *Credentials, onLogin func(a *Account)) {
+	W.RunInBackground(nil, func() func() {
+		authURL, requestToken, err := startLogin()
+		if err != nil {
+			showError("Could not start login:", err)
+			return nil
+		}
+		logInfo("Authorize gowitt by visiting", authURL)
+		openBrowser(authURL)
+
+		return func() {
+			OpenPrompt(W, "PIN from twitter.com:", func(pin string) {
+				W.RunInBackground(nil, func() func() {
+					account, err := exchangePIN(requestToken, pin)
+					if err != nil {
+						showError("Could not log in:", err)
+						return nil
+					}
+					return func() {
+						stored, err := addAccount(c, account)
+						if err != nil {
+							showError("Could not log in:", err)
+							return
+						}
+						onLogin(stored)
+					}
+				})
+			})
+		}
+	})
+}
+
+// Menu to switch to any of the stored accounts, or log into a new one
+func AccountMenuItems(W *XWindow, c *Credentials, onSwitch func(a *Account)) []MenuItem {
+	var items []MenuItem
+	for i := range c.Accounts {
+		account := &c.Accounts[i]
+		label := "@" + account.ScreenName
+		if account == currentAccount(c) {
+			label = "✓ " + label
+		}
+		items = append(items, MenuItem{label, func() {
+			c.Current = accountKey(account)
+			if err := saveCredentials(c); err != nil {
+				showError("Could not save credentials:", err)
+			}
+			onSwitch(account)
+		}})
+	}
+	items = append(items, MenuItem{"Add account…", func() {
+		LoginInWindow(W, c, onSwitch)
+	}})
+	return items
+}
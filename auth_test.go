@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestAccountKeys(t *testing.T) {
+	c := &Credentials{Accounts: []Account{
+		{ScreenName: "alice", UserID: "12"},
+		{Backend: "mastodon", Instance: "https://one.example", ScreenName: "alice", UserID: "12"},
+		{Backend: "mastodon", Instance: "https://two.example", ScreenName: "alice", UserID: "12"},
+	}}
+	keys := make(map[string]bool)
+	for i := range c.Accounts {
+		keys[accountKey(&c.Accounts[i])] = true
+	}
+	if len(keys) != len(c.Accounts) {
+		t.Fatalf("accounts called alice share keys: %v", keys)
+	}
+
+	for i := range c.Accounts {
+		c.Current = accountKey(&c.Accounts[i])
+		if a := currentAccount(c); a != &c.Accounts[i] {
+			t.Errorf("current account for %q is %+v", c.Current, a)
+		}
+	}
+	// Credentials saved before keys have the screen name
+	c.Current = "alice"
+	if a := currentAccount(c); a != &c.Accounts[0] {
+		t.Errorf("current account for a screen name is %+v", a)
+	}
+
+	renamed := Account{ScreenName: "alice2", UserID: "12", Token: "new"}
+	if !sameAccount(&c.Accounts[0], &renamed) {
+		t.Error("a renamed account isn't the same one")
+	}
+	if sameAccount(&c.Accounts[1], &c.Accounts[2]) {
+		t.Error("accounts on two instances are the same one")
+	}
+	legacy := Account{ScreenName: "alice"}
+	if !sameAccount(&legacy, &c.Accounts[0]) || sameAccount(&legacy, &renamed) {
+		t.Error("accounts without user IDs don't go by screen name")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ChimeraCoder/anaconda"
+	"net/url"
+	"strconv"
+)
+
+// A social network we can read timelines from and act on posts
+type Backend interface {
+	// Newest posts of a timeline that are newer than sinceID and not newer than
+	// maxID, newest first. Either can be 0 for no limit
+	Timeline(source TimelineSource, sinceID, maxID int64, count int) ([]Post, error)
+	Post(ID int64) (Post, error)
+	Favourite(ID int64) error
+	Boost(ID int64) error
+	Reply(to *Post, text string) (Post, error)
+	// Web page of a post, for sharing
+	PostURL(author string, ID int64) string
+	// Whether the network has this kind of timeline
+	Supports(source TimelineSource) bool
+}
+
+type TwitterBackend struct {
+	API *anaconda.TwitterApi
+}
+
+func (b *TwitterBackend) Timeline(source TimelineSource, sinceID, maxID int64, count int) ([]Post, error) {
+	v := url.Values{"count": {strconv.Itoa(count)}}
+	if sinceID != 0 {
+		v.Set("since_id", strconv.FormatInt(sinceID, 10))
+	}
+	if maxID != 0 {
+		v.Set("max_id", strconv.FormatInt(maxID, 10))
+	}
+	tweets, err := fetchTimeline(b.API, source, v)
+	if err != nil {
+		return nil, twitterError("fetch "+timelineTitle(source), err)
+	}
+	Result := make([]Post, len(tweets))
+	for i := range tweets {
+		Result[i] = postFromTweet(&tweets[i])
+	}
+	return Result, nil
+}
+
+func (b *TwitterBackend) Post(ID int64) (Post, error) {
+	t, err := b.API.GetTweet(ID, nil)
+	if err != nil {
+		return Post{}, twitterError("fetch tweet", err)
+	}
+	return postFromTweet(&t), nil
+}
+
+func (b *TwitterBackend) Favourite(ID int64) error {
+	_, err := b.API.Favorite(ID)
+	return twitterError("favourite", err)
+}
+
+func (b *TwitterBackend) Boost(ID int64) error {
+	_, err := b.API.Retweet(ID, false)
+	return twitterError("retweet", err)
+}
+
+func (b *TwitterBackend) Reply(to *Post, text string) (Post, error) {
+	// Twitter only threads replies that mention the author
+	status := "@" + to.Author.ScreenName + " " + text
+	t, err := b.API.PostTweet(status, url.Values{
+		"in_reply_to_status_id": {strconv.FormatInt(to.ID, 10)},
+	})
+	if err != nil {
+		return Post{}, twitterError("reply", err)
+	}
+	return postFromTweet(&t), nil
+}
+
+func (b *TwitterBackend) PostURL(author string, ID int64) string {
+	return fmt.Sprintf("https://twitter.com/%s/status/%d", author, ID)
+}
+
+func (b *TwitterBackend) Supports(source TimelineSource) bool {
+	switch source.Kind {
+	case "home", "mentions", "list", "user", "search":
+		return true
+	}
+	return false
+}
+
+// The columns of the config a backend can fetch. Every account shares the
+// config, and columns of kinds only other networks have would fail every poll
+func backendColumns(backend Backend, columns []TimelineSource) []TimelineSource {
+	var Result []TimelineSource
+	for _, s := range columns {
+		if backend.Supports(s) {
+			Result = append(Result, s)
+		} else {
+			logDebug("Skipping the", timelineTitle(s), "column, the backend doesn't have it")
+		}
+	}
+	return Result
+}
+
+// Picks the backend an account was created for. Accounts from before
+// backends existed are twitter ones
+func newBackend(a *Account) Backend {
+	switch a.Backend {
+	case "mastodon":
+		return NewMastodonBackend(a.Instance, a.Token)
+	}
+	return &TwitterBackend{API: newTwitterApi(a)}
+}
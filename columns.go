@@ -0,0 +1,453 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/boltdb/bolt"
+	"net/url"
+	"strconv"
+)
+
+const ColumnTweets = 20 // tweets loaded into each column's buffer
+
+// Rows are sized after the font, see XWindow.LineHeight
+
+// Pixels taken by column titles or the tab bar
+func columnHeaderHeight(W *XWindow) float64 {
+	th := currentTheme()
+	return W.LineHeight + th.SmallPadding + th.Padding
+}
+
+type TimelineSource struct {
+	Kind string `json:"kind"`          // "home", "mentions", "list", "user", "search", or "public" on mastodon
+	Arg  string `json:"arg,omitempty"` // list ID, screen name or search query
+}
+
+type Column struct {
+	Source TimelineSource
+	Tweets *TweetsBuffer
+	Scroll float64
+	Hidden int // tweets left out by the mute filters on the last reload
+	// Unread tracking
+	ReadMarker  int64 // newest tweet ID that has been on screen
+	Divider     int64 // tweets newer than this get a "new tweets" divider above the older ones
+	Unread      int
+	markerMoved bool  // ReadMarker changed and has to be stored
+	JumpTo      int64 // tweet to scroll to on the next redraw
+	generation  int   // counts reloads, so tweets prepared for an older one are dropped
+	// Missing tweets, shown as rows to click to load them
+	Gaps       []Gap
+	LoadingGap int64 // Newer of the gap being loaded, 0 if none
+}
+
+// Tweets of a column loaded and laid out by prepareColumn, waiting to replace
+// the ones it shows
+type PreparedColumn struct {
+	Column     *Column
+	DB         *bolt.DB // of the column's account
+	Generation int
+	Tweets     []*TweetInfo
+	Hidden     int
+	Gaps       []Gap
+	Fill       bool // the tweets of a gap, from prepareGapFill, go in between the shown ones
+}
+
+// Name of the bucket in the timelines bucket holding the source's tweets
+func timelineKey(s TimelineSource) string {
+	if s.Arg == "" {
+		return s.Kind
+	}
+	return s.Kind + ":" + s.Arg
+}
+
+func timelineTitle(s TimelineSource) string {
+	switch s.Kind {
+	case "home":
+		return "Home"
+	case "mentions":
+		return "Mentions"
+	case "public":
+		return "Public"
+	case "list":
+		return "List " + s.Arg
+	case "user":
+		return "@" + s.Arg
+	case "search":
+		return "Search: " + s.Arg
+	}
+	return s.Kind
+}
+
+func fetchTimeline(api *anaconda.TwitterApi, s TimelineSource, v url.Values) ([]anaconda.Tweet, error) {
+	switch s.Kind {
+	case "home":
+		return api.GetHomeTimeline(v)
+	case "mentions":
+		return api.GetMentionsTimeline(v)
+	case "list":
+		listID, err := strconv.ParseInt(s.Arg, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return api.GetListTweets(listID, true, v)
+	case "user":
+		v.Set("screen_name", s.Arg)
+		return api.GetUserTimeline(v)
+	case "search":
+		sr, err := api.GetSearch(s.Arg, v)
+		return sr.Statuses, err
+	}
+	return nil, errors.New("Unknown timeline kind " + s.Kind)
+}
+
+func NewColumn(R Renderer, DB *bolt.DB, filters *Filters, s TimelineSource) (*Column, error) {
+	c := &Column{Source: s, Tweets: NewTweetsBuffer(ColumnTweets)}
+	if err := ReloadColumn(R, DB, filters, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Loads the newest tweets of the column that pass the filters and lays them out.
+// It doesn't change the column, so it can run on a worker goroutine as long as
+// R lays out on any goroutine and nothing else uses filters meanwhile
+func prepareColumn(R Renderer, DB *bolt.DB, filters *Filters, c *Column, generation int) (*PreparedColumn, error) {
+	Result := &PreparedColumn{Column: c, DB: DB, Generation: generation}
+	posts, err := getLastNPosts(DB, timelineKey(c.Source), ColumnTweets, func(h PostHeader) bool {
+		if isHeaderFiltered(filters, h) {
+			Result.Hidden++
+			return true
+		}
+		return false
+	}, func(t *Post) bool {
+		if isFiltered(filters, t) {
+			Result.Hidden++
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if Result.Gaps, err = getGaps(DB, timelineKey(c.Source)); err != nil {
+		return nil, err
+	}
+	for i := range posts {
+		Result.Tweets = append(Result.Tweets, GenerateTweetInfo(R, &posts[i]))
+	}
+	return Result, nil
+}
+
+// Lays out the new tweets fetched for a gap of the column. Like prepareColumn
+// it can run on a worker goroutine
+func prepareGapFill(R Renderer, DB *bolt.DB, filters *Filters, c *Column, posts []Post) (*PreparedColumn, error) {
+	Result := &PreparedColumn{Column: c, DB: DB, Fill: true}
+	var err error
+	if Result.Gaps, err = getGaps(DB, timelineKey(c.Source)); err != nil {
+		return nil, err
+	}
+	for i := range posts {
+		if isFiltered(filters, &posts[i]) {
+			Result.Hidden++
+			continue
+		}
+		Result.Tweets = append(Result.Tweets, GenerateTweetInfo(R, &posts[i]))
+	}
+	return Result, nil
+}
+
+func discardPreparedColumn(p *PreparedColumn) {
+	for _, t := range p.Tweets {
+		DestroyTweetInfo(t)
+	}
+	p.Tweets = nil
+}
+
+// Replaces the column's tweets with prepared ones, unless the column was
+// reloaded after they were prepared. The tweets of a gap go in whatever the
+// column shows by then, a reload may have started before they were stored
+func applyPreparedColumn(p *PreparedColumn) error {
+	c := p.Column
+	if p.Fill {
+		c.Gaps = p.Gaps
+		c.LoadingGap = 0
+		fillColumn(c, p.Tweets, p.Hidden)
+		return nil
+	}
+	if p.Generation != c.generation {
+		discardPreparedColumn(p)
+		return nil
+	}
+	c.Hidden = p.Hidden
+	c.Gaps = p.Gaps
+	c.LoadingGap = 0
+	ClearTweetsBuffer(c.Tweets)
+	for _, t := range p.Tweets {
+		AddOlder(c.Tweets, t)
+	}
+
+	var err error
+	if c.ReadMarker, err = getReadMarker(p.DB, timelineKey(c.Source)); err != nil {
+		return err
+	}
+	c.Divider = c.ReadMarker
+	c.Unread, err = countNewerTweets(p.DB, timelineKey(c.Source), c.ReadMarker)
+	return err
+}
+
+// Puts the tweets of a gap in between the ones the column shows. When the
+// buffer is full, tweets far from the middle of the screen make room. If
+// newer ones go, the scroll position would be off, so it jumps to the middle
+func fillColumn(c *Column, tweets []*TweetInfo, hidden int) {
+	c.Hidden += hidden
+	newest := c.Tweets.Newest
+	for _, t := range tweets {
+		InsertTweet(c.Tweets, t)
+	}
+	if center := c.Tweets.CenterTweet; newest != nil && c.Tweets.Newest != newest && center != nil {
+		c.JumpTo = center.ID
+	}
+}
+
+// Throws away the column's tweets and loads the newest ones from the database
+// that pass the filters
+func ReloadColumn(R Renderer, DB *bolt.DB, filters *Filters, c *Column) error {
+	c.generation++
+	p, err := prepareColumn(R, DB, filters, c, c.generation)
+	if err != nil {
+		return err
+	}
+	return applyPreparedColumn(p)
+}
+
+// Stores the read markers that moved since the last call, and updates the unread counts
+func SaveReadMarkers(W *XWindow, DB *bolt.DB) error {
+	changed := false
+	for _, c := range W.Columns {
+		if !c.markerMoved {
+			continue
+		}
+		c.markerMoved = false
+		changed = true
+		if err := setReadMarker(DB, timelineKey(c.Source), c.ReadMarker); err != nil {
+			return err
+		}
+		var err error
+		if c.Unread, err = countNewerTweets(DB, timelineKey(c.Source), c.ReadMarker); err != nil {
+			return err
+		}
+	}
+	if changed {
+		UpdateWindowTitle(W)
+	}
+	return nil
+}
+
+func UpdateWindowTitle(W *XWindow) {
+	unread := 0
+	for _, c := range W.Columns {
+		unread += c.Unread
+	}
+	title := "gowitt"
+	if unread > 0 {
+		title = fmt.Sprintf("gowitt (%d)", unread)
+	}
+	if W.ScreenName != "" {
+		title += " - @" + W.ScreenName
+	}
+	SetWindowTitle(W, title)
+}
+
+// Makes the next redraw scroll the column to its oldest unread tweet, if it's loaded
+func JumpToOldestUnread(c *Column) {
+	for t := c.Tweets.Oldest; t != nil; t = t.Newer {
+		if t.ID > c.ReadMarker {
+			c.JumpTo = t.ID
+			return
+		}
+	}
+}
+
+// Returns the horizontal extent of a column on screen
+func columnRect(W *XWindow, i int, windowWidth float64) (x, width float64) {
+	if W.Tabs || len(W.Columns) == 0 {
+		return 0, windowWidth
+	}
+	width = windowWidth / float64(len(W.Columns))
+	return float64(i) * width, width
+}
+
+// Returns the index of the column under the given window coordinate
+func columnAt(W *XWindow, x int, windowWidth float64) int {
+	if W.Tabs {
+		return W.ActiveColumn
+	}
+	for i := range W.Columns {
+		cx, cw := columnRect(W, i, windowWidth)
+		if float64(x) >= cx && float64(x) < cx+cw {
+			return i
+		}
+	}
+	return W.ActiveColumn
+}
+
+func DrawColumns(W *XWindow, windowWidth, windowHeight float64, click MouseClick) {
+	th := currentTheme()
+	if click.Button != 0 {
+		W.ActiveColumn = columnAt(W, click.X, windowWidth)
+	}
+	headerHeight, dividerHeight, gapHeight := columnHeaderHeight(W), newTweetsDividerHeight(W), gapRowHeight(W)
+
+	// Tab bar, or a title on top of every column
+	for i, c := range W.Columns {
+		x, width := columnRect(W, i, windowWidth)
+		if W.Tabs {
+			width = windowWidth / float64(len(W.Columns))
+			x = float64(i) * width
+			if click.Button == 1 && float64(click.Y) < headerHeight && float64(click.X) >= x && float64(click.X) < x+width {
+				W.ActiveColumn = i
+			}
+		}
+		if i == W.ActiveColumn {
+			setColor(W, th.HeaderActive)
+		} else {
+			setColor(W, th.Header)
+		}
+		C.cairo_rectangle(W.Cairo, C.double(x), 0, C.double(width), C.double(headerHeight))
+		C.cairo_fill(W.Cairo)
+		setColor(W, th.Text)
+		title := timelineTitle(c.Source)
+		if c.Hidden > 0 {
+			title += fmt.Sprintf("  (%d muted)", c.Hidden)
+		}
+		DrawText(W, x+th.Padding, th.SmallPadding, title)
+	}
+	if float64(click.Y) < headerHeight {
+		click = MouseClick{}
+	}
+
+	for i, c := range W.Columns {
+		if W.Tabs && i != W.ActiveColumn {
+			continue
+		}
+		x, width := columnRect(W, i, windowWidth)
+
+		if c.JumpTo != 0 {
+			yPos := 0.0
+			for t := c.Tweets.Newest; t != nil && t.ID != c.JumpTo; t = t.Older {
+				yPos += TweetHeight(t, width-2*th.Padding)
+				if t.Older != nil && t.ID > c.Divider && t.Older.ID <= c.Divider {
+					yPos += dividerHeight
+				}
+				if _, ok := gapBelow(c, t); ok {
+					yPos += gapHeight
+				}
+			}
+			c.Scroll = -yPos
+			c.JumpTo = 0
+		}
+
+		C.cairo_save(W.Cairo)
+		C.cairo_rectangle(W.Cairo, C.double(x), C.double(headerHeight), C.double(width), C.double(windowHeight-headerHeight))
+		C.cairo_clip(W.Cairo)
+		yPos := headerHeight + th.Padding + c.Scroll
+		middle := (headerHeight + windowHeight) / 2
+		for t := c.Tweets.Newest; t != nil; t = t.Older {
+			if c.Divider != 0 && t.Newer != nil && t.Newer.ID > c.Divider && t.ID <= c.Divider {
+				drawNewTweetsDivider(W, x, yPos, width)
+				yPos += dividerHeight
+			}
+			top := yPos
+			yPos += DrawTweet(W, t, x+th.Padding, yPos, width-2*th.Padding, false, click)
+
+			// Anything fully on screen counts as read
+			if t.ID > c.ReadMarker && top >= headerHeight && yPos <= windowHeight {
+				c.ReadMarker = t.ID
+				c.markerMoved = true
+			}
+			if top <= middle && yPos > middle {
+				centerTweet(c.Tweets, t)
+			}
+
+			if g, ok := gapBelow(c, t); ok {
+				loading := c.LoadingGap == g.Newer
+				if drawGapRow(W, x, yPos, width, loading, click) && !loading && W.LoadGap != nil {
+					c.LoadingGap = g.Newer
+					W.LoadGap(c, g)
+				}
+				yPos += gapHeight
+			}
+		}
+		C.cairo_restore(W.Cairo)
+	}
+}
+
+func newTweetsDividerHeight(W *XWindow) float64 {
+	return W.LineHeight + 2*currentTheme().SmallPadding
+}
+
+func drawNewTweetsDivider(W *XWindow, x, yPos, width float64) {
+	th := currentTheme()
+	height := newTweetsDividerHeight(W)
+	setColor(W, th.Accent)
+	C.cairo_rectangle(W.Cairo, C.double(x+th.Padding), C.double(yPos+height/2-1), C.double(width-2*th.Padding), 2)
+	C.cairo_fill(W.Cairo)
+
+	label := "new tweets"
+	w, h := TextSize(W, label)
+	labelX := x + (width-w)/2
+	setColor(W, th.Background)
+	C.cairo_rectangle(W.Cairo, C.double(labelX-th.Padding), C.double(yPos), C.double(w+2*th.Padding), C.double(height))
+	C.cairo_fill(W.Cairo)
+	setColor(W, th.Accent)
+	DrawText(W, labelX, yPos+(height-h)/2, label)
+}
+
+func gapRowHeight(W *XWindow) float64 {
+	return W.LineHeight + 3*currentTheme().Padding
+}
+
+// The gap between a tweet and the next older one in the column, if any
+func gapBelow(c *Column, t *TweetInfo) (Gap, bool) {
+	if t.Older == nil {
+		return Gap{}, false
+	}
+	for _, g := range c.Gaps {
+		if t.ID >= g.Newer && t.Older.ID <= g.Older {
+			return g, true
+		}
+	}
+	return Gap{}, false
+}
+
+// Draws the row standing for missing tweets, and returns whether it was clicked
+func drawGapRow(W *XWindow, x, yPos, width float64, loading bool, click MouseClick) bool {
+	th := currentTheme()
+	height := gapRowHeight(W)
+	hover := float64(W.MouseX) >= x && float64(W.MouseX) < x+width && float64(W.MouseY) >= yPos && float64(W.MouseY) < yPos+height
+	if hover && !loading {
+		setColor(W, th.Hover)
+	} else {
+		setColor(W, th.Header)
+	}
+	roundedRectangle(W, x+th.Padding, yPos, width-2*th.Padding, height-th.Padding)
+	C.cairo_fill(W.Cairo)
+
+	label := "Load more"
+	if loading {
+		label = "Loading…"
+	}
+	w, h := TextSize(W, label)
+	setColor(W, th.Accent)
+	DrawText(W, x+(width-w)/2, yPos+(height-th.Padding-h)/2, label)
+
+	return click.Button == 1 && float64(click.X) >= x && float64(click.X) < x+width &&
+		float64(click.Y) >= yPos && float64(click.Y) < yPos+height
+}
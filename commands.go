@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Something gowitt can do from the command line, as in "gowitt fetch"
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+func commandList() []Command {
+	return []Command{
+		{"ui", "open the window (the default)", uiCommand},
+		{"tui", "show the timelines in the terminal", tuiCommand},
+		{"fetch", "fetch new posts of every account and exit", fetchCommand},
+		{"list", "[-n count] [timeline]  print the newest stored posts", listCommand},
+		{"search", "[-n count] words...  print stored posts containing all the words", searchCommand},
+		{"serve", "[-addr host:port] [timeline]  serve a timeline as a web page", serveCommand},
+		{"gc", "[-days n]  delete cached images not used for a while", gcCommand},
+		{"compact", "shrink the databases after old posts were pruned", compactCommand},
+		{"export", "jsonl|csv [file], html <directory>  export the stored posts", exportCommand},
+		{"import", "<tweets.js>  import the tweets of a twitter archive", importCommand},
+		{"help", "show this", helpCommand},
+	}
+}
+
+func runCommand(name string, args []string) error {
+	for _, c := range commandList() {
+		if c.Name == name {
+			return c.Run(args)
+		}
+	}
+	helpCommand(nil)
+	return errors.New("Unknown command " + name)
+}
+
+func helpCommand(args []string) error {
+	fmt.Println("Usage: gowitt [command]")
+	for _, c := range commandList() {
+		fmt.Printf("  %-8s %s\n", c.Name, c.Usage)
+	}
+	return nil
+}
+
+func uiCommand(args []string) error {
+	return runUI()
+}
+
+// Fetches every configured timeline of every account once, like the window's
+// poller does. Meant to be run from cron
+func fetchCommand(args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	credentials, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	if len(credentials.Accounts) == 0 {
+		return errors.New("Not logged in, run gowitt once to log in")
+	}
+
+	var Result error
+	for i := range credentials.Accounts {
+		account := &credentials.Accounts[i]
+		DB, err := openAccountDB(credentials, account)
+		if err != nil {
+			fmt.Println("Could not open account @"+account.ScreenName, err)
+			Result = errors.New("Some accounts could not be fetched")
+			continue
+		}
+		backend := newBackend(account)
+		for _, s := range backendColumns(backend, config.Columns) {
+			newPosts, err := getTimelineData(DB, backend, s)
+			if err != nil {
+				fmt.Println("Could not fetch", timelineTitle(s), "of @"+account.ScreenName, err)
+				Result = errors.New("Some timelines could not be fetched")
+				continue
+			}
+			fmt.Printf("@%s %s: %d new\n", account.ScreenName, timelineTitle(s), len(newPosts))
+		}
+		if twitter, ok := backend.(*TwitterBackend); ok {
+			if _, err := getDirectMessages(DB, twitter.API); err != nil {
+				fmt.Println("Could not fetch direct messages of @"+account.ScreenName, err)
+			}
+		}
+		DB.Close()
+	}
+	return Result
+}
+
+func printPost(p *Post, now time.Time) {
+	shown := shownPost(p)
+	header := fmt.Sprintf("%s @%s · %s", shown.Author.Name, shown.Author.ScreenName, relativeAge(p.CreatedAt, now))
+	if p.Reblog != nil {
+		header += " · ⇄ @" + p.Author.ScreenName
+	}
+	fmt.Println(header)
+	fmt.Println(shown.Text)
+	fmt.Println()
+}
+
+// Newest posts first, oldest at the bottom right above the prompt
+func printPosts(posts []Post) {
+	now := time.Now()
+	for i := len(posts) - 1; i >= 0; i-- {
+		printPost(&posts[i], now)
+	}
+}
+
+func listCommand(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	count := flags.Int("n", ColumnTweets, "how many posts to print")
+	flags.Parse(args)
+	timeline := "home"
+	if flags.NArg() > 0 {
+		timeline = flags.Arg(0)
+	}
+
+	DB, _, err := openCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+	filters, err := loadFilters(DB)
+	if err != nil {
+		return err
+	}
+	posts, err := getLastNPosts(DB, timeline, *count, func(h PostHeader) bool {
+		return isHeaderFiltered(filters, h)
+	}, func(p *Post) bool {
+		return isFiltered(filters, p)
+	})
+	if err != nil {
+		return err
+	}
+	printPosts(posts)
+	return nil
+}
+
+// Searches the stored posts, without asking the backend
+func searchPosts(DB *bolt.DB, words []string, count int) ([]Post, error) {
+	posts, err := allPosts(DB)
+	if err != nil {
+		return nil, err
+	}
+	var Result []Post
+	for i := len(posts) - 1; i >= 0 && len(Result) < count; i-- {
+		shown := shownPost(&posts[i])
+		text := strings.ToLower(shown.Text + " @" + shown.Author.ScreenName)
+		found := true
+		for _, word := range words {
+			if !strings.Contains(text, strings.ToLower(word)) {
+				found = false
+				break
+			}
+		}
+		if found {
+			Result = append(Result, posts[i])
+		}
+	}
+	return Result, nil
+}
+
+func searchCommand(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	count := flags.Int("n", ColumnTweets, "how many posts to print")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("Usage: gowitt search [-n count] words...")
+	}
+
+	DB, _, err := openCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+	posts, err := searchPosts(DB, flags.Args(), *count)
+	if err != nil {
+		return err
+	}
+	printPosts(posts)
+	return nil
+}
+
+// Serves the newest posts of a timeline as the same page "gowitt export html" writes
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "where to listen")
+	flags.Parse(args)
+	timeline := "home"
+	if flags.NArg() > 0 {
+		timeline = flags.Arg(0)
+	}
+
+	DB, a, err := openCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		posts, err := getLastNPosts(DB, timeline, 200, nil, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var archived []archivedPost
+		for i := range posts {
+			e := exportPost(&posts[i])
+			archived = append(archived, archivedPost{e.ID, e, e.AvatarURL})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := writeArchiveHTML(w, "@"+a.ScreenName+" "+timeline, archived); err != nil {
+			fmt.Println("Could not serve page:", err)
+		}
+	})
+	fmt.Println("Serving", timeline, "on http://"+*addr)
+	return http.ListenAndServe(*addr, nil)
+}
+
+// Deletes the images of the cache that weren't used for some days. Anything
+// still needed is downloaded again
+func gcCommand(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	days := flags.Int("days", 30, "delete images not used for this many days")
+	flags.Parse(args)
+
+	files, err := ioutil.ReadDir(imageCacheDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -*days)
+	var deleted int
+	var freed int64
+	for _, f := range files {
+		if f.IsDir() || f.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(imageCacheDir(), f.Name())); err != nil {
+			fmt.Println("Could not delete", f.Name(), err)
+			continue
+		}
+		deleted++
+		freed += f.Size()
+	}
+	fmt.Printf("Deleted %d cached images, %d KiB\n", deleted, freed/1024)
+	return nil
+}
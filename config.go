@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const ConfigFileName = "config.json"
+
+type Config struct {
+	Layout        string             `json:"layout"` // "columns" for side by side, or "tabs"
+	Columns       []TimelineSource   `json:"columns"`
+	PollMinutes   int                `json:"poll_minutes"` // 0 disables fetching new tweets
+	Notifications NotificationConfig `json:"notifications"`
+	Retention     RetentionConfig    `json:"retention"`
+	LogLevel      string             `json:"log_level"` // "debug", "info", "warn" or "error"
+	Theme         string             `json:"theme"`     // "dark", "light", or a file in the themes directory
+	// From before themes, see loadConfigTheme
+	Font      string `json:"font,omitempty"`
+	EmojiFont string `json:"emoji_font,omitempty"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Layout:      "columns",
+		LogLevel:    "info",
+		Theme:       "dark",
+		PollMinutes: 5,
+		Notifications: NotificationConfig{
+			Mentions:       true,
+			DirectMessages: true,
+		},
+		Columns: []TimelineSource{
+			{Kind: "home"},
+		},
+		Retention: RetentionConfig{
+			Days:       90,
+			PruneHours: 6,
+		},
+	}
+}
+
+func configDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "gowitt")
+}
+
+// Loads the config file, creating it with the default settings if it doesn't exist yet
+func loadConfig() (*Config, error) {
+	data, err := ioutil.ReadFile(filepath.Join(configDir(), ConfigFileName))
+	if os.IsNotExist(err) {
+		Result := defaultConfig()
+		return Result, saveConfig(Result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	Result := defaultConfig()
+	if err := json.Unmarshal(data, Result); err != nil {
+		return nil, err
+	}
+	return Result, nil
+}
+
+func saveConfig(c *Config) error {
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave a truncated config behind
+	path := filepath.Join(configDir(), ConfigFileName)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
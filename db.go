@@ -2,12 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/ChimeraCoder/anaconda"
 	"github.com/boltdb/bolt"
+	"strconv"
+	"time"
 )
 
-func initDB() (*bolt.DB, error) {
-	DB, err := bolt.Open("tweets.db", 0777, nil)
+func initDB(path string) (*bolt.DB, error) {
+	// Only one process can have the database open, don't wait forever for another gowitt
+	DB, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is in use by another gowitt", path)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -17,34 +24,293 @@ func initDB() (*bolt.DB, error) {
 		return nil, err
 	}
 
+	if _, err = Tx.CreateBucketIfNotExists([]byte("readmarkers")); err != nil {
+		return nil, err
+	}
+
+	if _, err = Tx.CreateBucketIfNotExists([]byte("settings")); err != nil {
+		return nil, err
+	}
+
+	if _, err = Tx.CreateBucketIfNotExists([]byte("dms")); err != nil {
+		return nil, err
+	}
+
+	if _, err = Tx.CreateBucketIfNotExists([]byte("dmconversations")); err != nil {
+		return nil, err
+	}
+
+	if _, err = Tx.CreateBucketIfNotExists([]byte("ranges")); err != nil {
+		return nil, err
+	}
+
+	if err = migrateDB(Tx); err != nil {
+		Tx.Rollback()
+		return nil, err
+	}
+
+	// Databases created before the reply index existed need it built once
+	if Tx.Bucket([]byte("replies")) == nil {
+		if _, err = Tx.CreateBucket([]byte("replies")); err != nil {
+			Tx.Rollback()
+			return nil, err
+		}
+		if err = rebuildReplyIndex(Tx); err != nil {
+			Tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// Same for timeline membership. Everything stored so far came from the home timeline
+	if Tx.Bucket([]byte("timelines")) == nil {
+		if _, err = Tx.CreateBucket([]byte("timelines")); err != nil {
+			Tx.Rollback()
+			return nil, err
+		}
+		err = Tx.Bucket([]byte("tweets")).ForEach(func(k, v []byte) error {
+			ID, err := strconv.ParseInt(string(k), 16, 64)
+			if err != nil {
+				return err
+			}
+			return addToTimeline(Tx, "home", ID)
+		})
+		if err != nil {
+			Tx.Rollback()
+			return nil, err
+		}
+	}
+
 	if err := Tx.Commit(); err != nil {
 		return nil, err
 	}
 	return DB, err
 }
 
-func getLastNTweets(DB *bolt.DB, TweetCnt int) ([]anaconda.Tweet, error) {
-	var Result []anaconda.Tweet
+// Version of the format of the values in the tweets bucket
+//
+//	1: anaconda.Tweet as JSON
+//	2: Post as JSON
+//	3: Post in the binary format of postcodec.go
+const SchemaVersion = 3
+
+func getSchemaVersion(Tx *bolt.Tx) int {
+	v := Tx.Bucket([]byte("settings")).Get([]byte("schema"))
+	if v == nil {
+		return 1
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 1
+	}
+	return version
+}
+
+// Rewrites every stored tweet in the current format
+func migrateDB(Tx *bolt.Tx) error {
+	version := getSchemaVersion(Tx)
+	if version == SchemaVersion {
+		return nil
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("Database format %d is newer than this gowitt supports", version)
+	}
+
+	// Buckets can't be modified while iterating them
+	Tweets := Tx.Bucket([]byte("tweets"))
+	var keys, values [][]byte
+	err := Tweets.ForEach(func(k, v []byte) error {
+		var post Post
+		if version == 1 {
+			var tweet anaconda.Tweet
+			if err := json.Unmarshal(v, &tweet); err != nil {
+				return err
+			}
+			post = postFromTweet(&tweet)
+		} else if err := json.Unmarshal(v, &post); err != nil {
+			return err
+		}
+		data, err := encodePost(&post)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte{}, k...))
+		values = append(values, data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := Tweets.Put(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return Tx.Bucket([]byte("settings")).Put([]byte("schema"), []byte(strconv.Itoa(SchemaVersion)))
+}
+
+// Returns the newest PostCnt posts of a timeline, newest first. Posts for
+// which SkipHeader or Skip return true are left out and don't count. Only the
+// header is decoded for SkipHeader, so posts it skips are never fully decoded.
+// Either can be nil
+func getLastNPosts(DB *bolt.DB, Timeline string, PostCnt int, SkipHeader func(PostHeader) bool, Skip func(*Post) bool) ([]Post, error) {
+	var Result []Post
 	Tx, err := DB.Begin(false)
 	if err != nil {
-		return []anaconda.Tweet{}, err
+		return []Post{}, err
 	}
-	Bucket := Tx.Bucket([]byte("tweets"))
-	Cursor := Bucket.Cursor()
+	defer Tx.Rollback()
+
+	Timelines := Tx.Bucket([]byte("timelines")).Bucket([]byte(Timeline))
+	if Timelines == nil {
+		return []Post{}, nil
+	}
+	Tweets := Tx.Bucket([]byte("tweets"))
+	Cursor := Timelines.Cursor()
 	k, v := Cursor.Last()
-	for i := 0; i < TweetCnt; i++ {
-		if k == nil {
-			break
-		}
-		var tweet anaconda.Tweet
-		if err := json.Unmarshal(v, &tweet); err != nil {
-			return []anaconda.Tweet{}, err
+	for len(Result) < PostCnt && k != nil {
+		if v = Tweets.Get(v); v != nil {
+			if SkipHeader != nil {
+				h, err := decodePostHeader(v)
+				if err != nil {
+					return []Post{}, err
+				}
+				if SkipHeader(h) {
+					k, v = Cursor.Prev()
+					continue
+				}
+			}
+			post, err := decodePost(v)
+			if err != nil {
+				return []Post{}, err
+			}
+			if Skip == nil || !Skip(post) {
+				Result = append(Result, *post)
+			}
 		}
-		Result = append(Result, tweet)
 		k, v = Cursor.Prev()
 	}
-	if err := Tx.Rollback(); err != nil {
-		return []anaconda.Tweet{}, err
-	}
 	return Result, nil
 }
+
+// Timeline buckets are keyed by fixed width hex IDs so they iterate in tweet
+// order, and map to the key of the tweet in the tweets bucket
+func addToTimeline(Tx *bolt.Tx, Timeline string, ID int64) error {
+	Bucket, err := Tx.Bucket([]byte("timelines")).CreateBucketIfNotExists([]byte(Timeline))
+	if err != nil {
+		return err
+	}
+	return Bucket.Put([]byte(fmt.Sprintf("%016x", ID)), tweetKey(ID))
+}
+
+func tweetKey(ID int64) []byte {
+	return []byte(strconv.FormatInt(ID, 16))
+}
+
+// Reply index keys are the parent ID followed by the reply ID, both as fixed
+// width hex, so all the replies to a tweet share a prefix and sort by ID
+func replyKey(Parent, Reply int64) []byte {
+	return []byte(fmt.Sprintf("%016x%016x", Parent, Reply))
+}
+
+func storePost(Tx *bolt.Tx, p *Post) error {
+	data, err := encodePost(p)
+	if err != nil {
+		return err
+	}
+	if err := Tx.Bucket([]byte("tweets")).Put(tweetKey(p.ID), data); err != nil {
+		return err
+	}
+	if p.InReplyTo != 0 {
+		return Tx.Bucket([]byte("replies")).Put(replyKey(p.InReplyTo, p.ID), []byte{})
+	}
+	return nil
+}
+
+func rebuildReplyIndex(Tx *bolt.Tx) error {
+	Replies := Tx.Bucket([]byte("replies"))
+	return Tx.Bucket([]byte("tweets")).ForEach(func(k, v []byte) error {
+		h, err := decodePostHeader(v)
+		if err != nil {
+			return err
+		}
+		if h.InReplyTo == 0 {
+			return nil
+		}
+		return Replies.Put(replyKey(h.InReplyTo, h.ID), []byte{})
+	})
+}
+
+// Returns nil if the post is not in the database
+func getPost(DB *bolt.DB, ID int64) (*Post, error) {
+	var Result *Post
+	err := DB.View(func(Tx *bolt.Tx) error {
+		v := Tx.Bucket([]byte("tweets")).Get(tweetKey(ID))
+		if v == nil {
+			return nil
+		}
+		var err error
+		Result, err = decodePost(v)
+		return err
+	})
+	return Result, err
+}
+
+// Returns the IDs of all the stored replies to a tweet, oldest first
+func getReplyIDs(DB *bolt.DB, ID int64) ([]int64, error) {
+	var Result []int64
+	prefix := []byte(fmt.Sprintf("%016x", ID))
+	err := DB.View(func(Tx *bolt.Tx) error {
+		Cursor := Tx.Bucket([]byte("replies")).Cursor()
+		for k, _ := Cursor.Seek(prefix); k != nil && len(k) == 32 && string(k[:16]) == string(prefix); k, _ = Cursor.Next() {
+			replyID, err := strconv.ParseUint(string(k[16:]), 16, 64)
+			if err != nil {
+				return err
+			}
+			Result = append(Result, int64(replyID))
+		}
+		return nil
+	})
+	return Result, err
+}
+
+// Read markers hold, per timeline, the newest tweet ID the user has seen. 0 if nothing was read yet
+func getReadMarker(DB *bolt.DB, Timeline string) (int64, error) {
+	var Result int64
+	err := DB.View(func(Tx *bolt.Tx) error {
+		v := Tx.Bucket([]byte("readmarkers")).Get([]byte(Timeline))
+		if v == nil {
+			return nil
+		}
+		var err error
+		Result, err = strconv.ParseInt(string(v), 16, 64)
+		return err
+	})
+	return Result, err
+}
+
+func setReadMarker(DB *bolt.DB, Timeline string, ID int64) error {
+	return DB.Update(func(Tx *bolt.Tx) error {
+		return Tx.Bucket([]byte("readmarkers")).Put([]byte(Timeline), tweetKey(ID))
+	})
+}
+
+// Counts the tweets in a timeline newer than the given ID
+func countNewerTweets(DB *bolt.DB, Timeline string, ID int64) (int, error) {
+	Result := 0
+	err := DB.View(func(Tx *bolt.Tx) error {
+		Bucket := Tx.Bucket([]byte("timelines")).Bucket([]byte(Timeline))
+		if Bucket == nil {
+			return nil
+		}
+		Cursor := Bucket.Cursor()
+		k, _ := Cursor.Seek([]byte(fmt.Sprintf("%016x", ID)))
+		if k != nil && string(k) == fmt.Sprintf("%016x", ID) {
+			k, _ = Cursor.Next()
+		}
+		for ; k != nil; k, _ = Cursor.Next() {
+			Result++
+		}
+		return nil
+	})
+	return Result, err
+}
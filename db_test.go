@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const BenchmarkPosts = 5000
+
+// Looks like a post from a home timeline: some entities, a few replies and retweets
+func benchmarkPost(i int) Post {
+	p := Post{
+		ID:             int64(1000000 + i),
+		Text:           fmt.Sprintf("Post number %d, with a link https://t.co/abcdef%d and a #hashtag to mute", i, i),
+		CreatedAt:      time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute),
+		Author:         User{ID: int64(i % 50), Name: fmt.Sprintf("User %d", i%50), ScreenName: fmt.Sprintf("user%d", i%50), AvatarURL: "https://pbs.twimg.com/profile_images/1/avatar_normal.png"},
+		FavouriteCount: i % 7,
+		Entities: []Entity{
+			{Kind: HashtagEntity, Text: "hashtag"},
+			{Kind: URLEntity, Text: fmt.Sprintf("https://t.co/abcdef%d", i), URL: "https://example.com/some/article"},
+		},
+	}
+	if i%5 == 0 {
+		p.InReplyTo, p.InReplyToUser = p.ID-1, int64((i-1)%50)
+	}
+	if i%4 == 0 {
+		original := p
+		original.ID, original.Author = p.ID-500000, User{ID: 99, Name: "Someone", ScreenName: "someone"}
+		p.Reblog, p.Text, p.Entities = &original, "", nil
+		p.InReplyTo, p.InReplyToUser = 0, 0
+	}
+	return p
+}
+
+// A new database with posts stored by encode in the home timeline. Returns
+// its path and how many bytes the encoded values take
+func fillBenchmarkDB(tb testing.TB, encode func(*Post) ([]byte, error)) (*bolt.DB, string, int) {
+	path := filepath.Join(tb.TempDir(), "tweets.db")
+	DB, err := initDB(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { DB.Close() })
+
+	valueBytes := 0
+	err = DB.Update(func(Tx *bolt.Tx) error {
+		for i := 0; i < BenchmarkPosts; i++ {
+			p := benchmarkPost(i)
+			data, err := encode(&p)
+			if err != nil {
+				return err
+			}
+			valueBytes += len(data)
+			if err := Tx.Bucket([]byte("tweets")).Put(tweetKey(p.ID), data); err != nil {
+				return err
+			}
+			if err := addToTimeline(Tx, "home", p.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return DB, path, valueBytes
+}
+
+func jsonPost(p *Post) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// getLastNPosts as it was when posts were stored as JSON
+func jsonLastNPosts(DB *bolt.DB, Timeline string, PostCnt int) ([]Post, error) {
+	var Result []Post
+	err := DB.View(func(Tx *bolt.Tx) error {
+		Tweets := Tx.Bucket([]byte("tweets"))
+		Cursor := Tx.Bucket([]byte("timelines")).Bucket([]byte(Timeline)).Cursor()
+		for k, v := Cursor.Last(); len(Result) < PostCnt && k != nil; k, v = Cursor.Prev() {
+			var post Post
+			if err := json.Unmarshal(Tweets.Get(v), &post); err != nil {
+				return err
+			}
+			Result = append(Result, post)
+		}
+		return nil
+	})
+	return Result, err
+}
+
+func BenchmarkGetLastNPosts(b *testing.B) {
+	// Called after the loop, ResetTimer drops the metrics
+	report := func(b *testing.B, path string, valueBytes int) {
+		b.ReportMetric(float64(valueBytes)/BenchmarkPosts, "B/post")
+		if info, err := os.Stat(path); err == nil {
+			b.ReportMetric(float64(info.Size()), "dbbytes")
+		}
+	}
+
+	b.Run("json", func(b *testing.B) {
+		DB, path, valueBytes := fillBenchmarkDB(b, jsonPost)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := jsonLastNPosts(DB, "home", ColumnTweets); err != nil {
+				b.Fatal(err)
+			}
+		}
+		report(b, path, valueBytes)
+	})
+	b.Run("varint", func(b *testing.B) {
+		DB, path, valueBytes := fillBenchmarkDB(b, encodePost)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := getLastNPosts(DB, "home", ColumnTweets, nil, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		report(b, path, valueBytes)
+	})
+	// Most posts muted by author, which only needs their headers
+	b.Run("varint-muted", func(b *testing.B) {
+		DB, path, valueBytes := fillBenchmarkDB(b, encodePost)
+		filters := &Filters{}
+		for i := 0; i < 45; i++ {
+			filters.MutedUsers = append(filters.MutedUsers, fmt.Sprintf("user%d", i))
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, err := getLastNPosts(DB, "home", ColumnTweets, func(h PostHeader) bool {
+				return isHeaderFiltered(filters, h)
+			}, func(p *Post) bool {
+				return isFiltered(filters, p)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		report(b, path, valueBytes)
+	})
+}
+
+func TestGetLastNPostsSkips(t *testing.T) {
+	DB, _, _ := fillBenchmarkDB(t, encodePost)
+	filters := &Filters{MutedUsers: []string{"USER1"}, HideRetweetsFrom: []string{"user2"}, HideReplies: true}
+
+	headerSkips, skips := 0, 0
+	posts, err := getLastNPosts(DB, "home", 100, func(h PostHeader) bool {
+		if isHeaderFiltered(filters, h) {
+			headerSkips++
+			return true
+		}
+		return false
+	}, func(p *Post) bool {
+		if isFiltered(filters, p) {
+			skips++
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 100 {
+		t.Fatalf("Got %d posts, want 100", len(posts))
+	}
+	for i, p := range posts {
+		if isFiltered(filters, &p) {
+			t.Errorf("Got filtered post %+v", p)
+		}
+		if i > 0 && p.ID >= posts[i-1].ID {
+			t.Errorf("Post %d isn't older than the one before", p.ID)
+		}
+	}
+	// Retweets of replies need the retweeted post to be skipped
+	if headerSkips == 0 || skips == 0 {
+		t.Errorf("%d posts skipped by their header and %d decoded, want some of both", headerSkips, skips)
+	}
+}
+
+func TestDecodePostHeader(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		p := benchmarkPost(i)
+		data, err := encodePost(&p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := decodePostHeader(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.ID != p.ID || h.ScreenName != p.Author.ScreenName || h.HasReblog != (p.Reblog != nil) ||
+			h.InReplyTo != p.InReplyTo || h.InReplyToUser != p.InReplyToUser || h.Author != p.Author.ID {
+			t.Errorf("Header %+v doesn't match %+v", h, p)
+		}
+	}
+}
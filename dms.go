@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/boltdb/bolt"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const DMTimelineKey = "dms" // sent through the poller's updates channel when new messages arrive
+
+// Direct messages are stored in the "dms" bucket by ID, and indexed in the
+// "dmconversations" bucket, which has a nested bucket per conversation partner
+// mapping message IDs to "s" for sent messages or "r" for received ones
+func storeDirectMessage(Tx *bolt.Tx, dm *anaconda.DirectMessage, sent bool) error {
+	data, err := json.Marshal(dm)
+	if err != nil {
+		return err
+	}
+	if err := Tx.Bucket([]byte("dms")).Put(tweetKey(dm.Id), data); err != nil {
+		return err
+	}
+
+	otherID := dm.SenderId
+	direction := []byte("r")
+	if sent {
+		otherID = dm.RecipientId
+		direction = []byte("s")
+	}
+	Conversation, err := Tx.Bucket([]byte("dmconversations")).CreateBucketIfNotExists(tweetKey(otherID))
+	if err != nil {
+		return err
+	}
+	return Conversation.Put([]byte(fmt.Sprintf("%016x", dm.Id)), direction)
+}
+
+// Fetches new sent and received messages into the database, and returns the
+// received ones that weren't stored before
+func getDirectMessages(DB *bolt.DB, api *anaconda.TwitterApi) ([]anaconda.DirectMessage, error) {
+	received, err := api.GetDirectMessages(url.Values{"count": {"50"}})
+	if err != nil {
+		return nil, err
+	}
+	sent, err := api.GetDirectMessagesSent(url.Values{"count": {"50"}})
+	if err != nil {
+		return nil, err
+	}
+	return storeDirectMessages(DB, received, sent)
+}
+
+// Stores fetched messages, and returns the received ones that are new. The
+// first fetch of an account only gets what was there before, and returns
+// none. It's recorded in the settings, as an account can have no messages
+func storeDirectMessages(DB *bolt.DB, received, sent []anaconda.DirectMessage) ([]anaconda.DirectMessage, error) {
+	var newMessages []anaconda.DirectMessage
+	err := DB.Update(func(Tx *bolt.Tx) error {
+		Settings := Tx.Bucket([]byte("settings"))
+		backfill := Settings.Get([]byte("dms_fetched")) == nil
+		for i := range received {
+			if !backfill && Tx.Bucket([]byte("dms")).Get(tweetKey(received[i].Id)) == nil {
+				newMessages = append(newMessages, received[i])
+			}
+			if err := storeDirectMessage(Tx, &received[i], false); err != nil {
+				return err
+			}
+		}
+		for i := range sent {
+			if err := storeDirectMessage(Tx, &sent[i], true); err != nil {
+				return err
+			}
+		}
+		return Settings.Put([]byte("dms_fetched"), []byte("1"))
+	})
+	return newMessages, err
+}
+
+type DMConversation struct {
+	User        anaconda.User // the other person
+	LastMessage anaconda.DirectMessage
+}
+
+type StoredDM struct {
+	Message anaconda.DirectMessage
+	Sent    bool
+}
+
+// Returns all conversations, the one with the newest message first
+func getDMConversations(DB *bolt.DB) ([]DMConversation, error) {
+	var Result []DMConversation
+	err := DB.View(func(Tx *bolt.Tx) error {
+		Messages := Tx.Bucket([]byte("dms"))
+		return Tx.Bucket([]byte("dmconversations")).ForEach(func(k, v []byte) error {
+			Conversation := Tx.Bucket([]byte("dmconversations")).Bucket(k)
+			if Conversation == nil {
+				return nil
+			}
+			lastKey, direction := Conversation.Cursor().Last()
+			if lastKey == nil {
+				return nil
+			}
+			lastID, err := strconv.ParseInt(string(lastKey), 16, 64)
+			if err != nil {
+				return err
+			}
+			var c DMConversation
+			if err := json.Unmarshal(Messages.Get(tweetKey(lastID)), &c.LastMessage); err != nil {
+				return err
+			}
+			c.User = c.LastMessage.Sender
+			if string(direction) == "s" {
+				c.User = c.LastMessage.Recipient
+			}
+			Result = append(Result, c)
+			return nil
+		})
+	})
+	sort.Slice(Result, func(i, j int) bool {
+		return Result[i].LastMessage.Id > Result[j].LastMessage.Id
+	})
+	return Result, err
+}
+
+// Returns the last MessageCnt messages exchanged with a user, oldest first
+func getDMConversation(DB *bolt.DB, UserID int64, MessageCnt int) ([]StoredDM, error) {
+	var Result []StoredDM
+	err := DB.View(func(Tx *bolt.Tx) error {
+		Conversation := Tx.Bucket([]byte("dmconversations")).Bucket(tweetKey(UserID))
+		if Conversation == nil {
+			return nil
+		}
+		Messages := Tx.Bucket([]byte("dms"))
+		Cursor := Conversation.Cursor()
+		for k, v := Cursor.Last(); k != nil && len(Result) < MessageCnt; k, v = Cursor.Prev() {
+			ID, err := strconv.ParseInt(string(k), 16, 64)
+			if err != nil {
+				return err
+			}
+			var dm StoredDM
+			if err := json.Unmarshal(Messages.Get(tweetKey(ID)), &dm.Message); err != nil {
+				return err
+			}
+			dm.Sent = string(v) == "s"
+			Result = append([]StoredDM{dm}, Result...)
+		}
+		return nil
+	})
+	return Result, err
+}
+
+func sendDirectMessage(DB *bolt.DB, api *anaconda.TwitterApi, screenName, text string) error {
+	dm, err := api.PostDMToScreenName(text, screenName)
+	if err != nil {
+		return err
+	}
+	return DB.Update(func(Tx *bolt.Tx) error {
+		return storeDirectMessage(Tx, &dm, true)
+	})
+}
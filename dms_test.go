@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/ChimeraCoder/anaconda"
+	"path/filepath"
+	"testing"
+)
+
+func directMessage(ID, senderID int64) anaconda.DirectMessage {
+	var Result anaconda.DirectMessage
+	Result.Id, Result.SenderId, Result.RecipientId = ID, senderID, 1
+	return Result
+}
+
+func TestStoreDirectMessages(t *testing.T) {
+	DB, err := initDB(filepath.Join(t.TempDir(), "tweets.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DB.Close()
+	fetch := func(received ...anaconda.DirectMessage) []anaconda.DirectMessage {
+		t.Helper()
+		newMessages, err := storeDirectMessages(DB, received, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newMessages
+	}
+
+	// A new account without messages, whose first one is new
+	if got := fetch(); len(got) != 0 {
+		t.Errorf("The first fetch returned %d messages", len(got))
+	}
+	if got := fetch(directMessage(100, 2)); len(got) != 1 || got[0].Id != 100 {
+		t.Errorf("Got %v for the first message of the account", got)
+	}
+	if got := fetch(directMessage(101, 3), directMessage(100, 2)); len(got) != 1 || got[0].Id != 101 {
+		t.Errorf("Got %v, want only the new message", got)
+	}
+
+	// An account that had messages before, which aren't new
+	DB2, err := initDB(filepath.Join(t.TempDir(), "tweets.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DB2.Close()
+	newMessages, err := storeDirectMessages(DB2, []anaconda.DirectMessage{directMessage(50, 2), directMessage(40, 3)}, nil)
+	if err != nil || len(newMessages) != 0 {
+		t.Errorf("The first fetch returned %d messages and %v", len(newMessages), err)
+	}
+}
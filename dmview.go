@@ -0,0 +1,222 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <pango/pango.h>
+#include <pango/pangocairo.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/boltdb/bolt"
+	"math"
+	"strings"
+)
+
+const DMConversationMessages = 50
+const DMBubbleMaxWidth = 0.7 // fraction of the window width a message bubble can take
+
+// A row of the conversation list has the name over the last message, next to the avatar
+func dmRowHeight(W *XWindow) float64 {
+	th := currentTheme()
+	return math.Max(th.AvatarSize, filterRowHeight(W)+W.LineHeight) + 2*th.Padding
+}
+
+type DMBubble struct {
+	Sent   bool
+	Layout *C.PangoLayout
+}
+
+// Shows the list of conversations, or the messages of one of them when Open is set
+type DMView struct {
+	DB            *bolt.DB
+	API           *anaconda.TwitterApi
+	Conversations []DMConversation
+	Open          *anaconda.User
+	Bubbles       []DMBubble
+	Scroll        float64
+	layouts       *LayoutFactory // that made the bubbles
+}
+
+func OpenDMView(W *XWindow, DB *bolt.DB, api *anaconda.TwitterApi) error {
+	conversations, err := getDMConversations(DB)
+	if err != nil {
+		return err
+	}
+	W.DMs = &DMView{DB: DB, API: api, Conversations: conversations, layouts: W.Layouts}
+	return nil
+}
+
+func CloseDMView(W *XWindow) {
+	if W.DMs == nil {
+		return
+	}
+	closeDMConversation(W.DMs)
+	W.DMs = nil
+}
+
+func closeDMConversation(v *DMView) {
+	for _, b := range v.Bubbles {
+		recycleLayout(v.layouts, b.Layout)
+	}
+	v.Bubbles = nil
+	v.Open = nil
+	v.Scroll = 0
+}
+
+func openDMConversation(W *XWindow, v *DMView, user anaconda.User) error {
+	messages, err := getDMConversation(v.DB, user.Id, DMConversationMessages)
+	if err != nil {
+		return err
+	}
+	closeDMConversation(v)
+	v.Open = &user
+	for _, m := range messages {
+		v.Bubbles = append(v.Bubbles, DMBubble{Sent: m.Sent, Layout: textLayout(v.layouts, m.Message.Text)})
+	}
+	return nil
+}
+
+// Reloads whatever the view shows, after new messages were stored
+func ReloadDMView(W *XWindow) error {
+	v := W.DMs
+	if v == nil {
+		return nil
+	}
+	conversations, err := getDMConversations(v.DB)
+	if err != nil {
+		return err
+	}
+	v.Conversations = conversations
+	if v.Open != nil {
+		scroll := v.Scroll
+		err = openDMConversation(W, v, *v.Open)
+		v.Scroll = scroll
+	}
+	return err
+}
+
+// Asks for a message and sends it to the open conversation
+func ComposeDM(W *XWindow) {
+	v := W.DMs
+	if v == nil || v.Open == nil {
+		return
+	}
+	if v.API == nil {
+		postBanner("Log in to send messages")
+		return
+	}
+	s, screenName := W.Session, v.Open.ScreenName
+	OpenPrompt(W, "Message to @"+screenName+":", func(text string) {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		W.RunInBackground(s, func() func() {
+			if err := sendDirectMessage(v.DB, v.API, screenName, text); err != nil {
+				showError("Could not send message:", err)
+				return nil
+			}
+			return func() {
+				if W.DMs != v {
+					return
+				}
+				if err := ReloadDMView(W); err != nil {
+					showError("Could not reload messages:", err)
+				}
+			}
+		})
+	})
+}
+
+func DrawDMView(W *XWindow, windowWidth, windowHeight float64, click MouseClick) {
+	th := currentTheme()
+	v := W.DMs
+	rowHeight := dmRowHeight(W)
+	listTop := 10.0 + v.Scroll + filterRowHeight(W) + th.Padding
+
+	// Handle clicks first, so an opened conversation shows right away
+	if v.Open == nil && click.Button == 1 && float64(click.Y) >= listTop {
+		row := int((float64(click.Y) - listTop) / (rowHeight + th.Padding))
+		if row < len(v.Conversations) {
+			if err := openDMConversation(W, v, v.Conversations[row].User); err != nil {
+				showError("Could not open conversation:", err)
+			}
+		}
+	}
+
+	if v.Open != nil {
+		drawDMConversation(W, v, windowWidth, windowHeight)
+		return
+	}
+
+	setColor(W, th.Text)
+	DrawText(W, th.Padding, 10+v.Scroll, "Direct messages (D to close)")
+
+	yPos := listTop
+	for _, c := range v.Conversations {
+		setColor(W, th.Card)
+		roundedRectangle(W, th.Padding, yPos, windowWidth-2*th.Padding, rowHeight)
+		C.cairo_fill(W.Cairo)
+
+		drawUserImage(W, c.User.ProfileImageURL, 2*th.Padding, yPos+th.Padding)
+
+		textX := 3*th.Padding + th.AvatarSize
+		setColor(W, th.Text)
+		DrawText(W, textX, yPos+th.Padding, c.User.Name+"  @"+c.User.ScreenName)
+		setColor(W, th.SecondaryText)
+		DrawText(W, textX, yPos+th.Padding+filterRowHeight(W), firstLine(c.LastMessage.Text, 60))
+		yPos += rowHeight + th.Padding
+	}
+}
+
+// Chat style: our messages on the right, theirs on the left next to their avatar
+func drawDMConversation(W *XWindow, v *DMView, windowWidth, windowHeight float64) {
+	th := currentTheme()
+	setColor(W, th.Text)
+	DrawText(W, th.Padding, 10, "@"+v.Open.ScreenName+"  (R to reply, Escape to go back)")
+
+	C.cairo_save(W.Cairo)
+	C.cairo_rectangle(W.Cairo, 0, C.double(columnHeaderHeight(W)+th.Padding), C.double(windowWidth), C.double(windowHeight))
+	C.cairo_clip(W.Cairo)
+
+	maxWidth := windowWidth*DMBubbleMaxWidth - 2*th.Padding
+	yPos := columnHeaderHeight(W) + 2*th.Padding + v.Scroll
+	for _, b := range v.Bubbles {
+		_, _, w, h := wrapLayout(v.layouts, b.Layout, maxWidth)
+		bubbleWidth := w + 2*th.Padding
+		bubbleHeight := h + 2*th.Padding
+
+		x := 2*th.Padding + th.AvatarSize
+		if b.Sent {
+			x = windowWidth - th.Padding - bubbleWidth
+			setColor(W, th.SentMessage)
+		} else {
+			drawUserImage(W, v.Open.ProfileImageURL, th.Padding, yPos)
+			setColor(W, th.ReceivedMessage)
+		}
+		roundedRectangle(W, x, yPos, bubbleWidth, bubbleHeight)
+		C.cairo_fill(W.Cairo)
+
+		C.cairo_move_to(W.Cairo, C.double(x+th.Padding), C.double(yPos+th.Padding))
+		setColor(W, th.Text)
+		showLayout(v.layouts, W.Cairo, b.Layout)
+
+		if !b.Sent && bubbleHeight < th.AvatarSize {
+			bubbleHeight = th.AvatarSize
+		}
+		yPos += bubbleHeight + th.Padding
+	}
+	C.cairo_restore(W.Cairo)
+}
+
+func firstLine(s string, maxRunes int) string {
+	if i := strings.Index(s, "\n"); i != -1 {
+		s = s[:i]
+	}
+	if r := []rune(s); len(r) > maxRunes {
+		s = string(r[:maxRunes]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ChimeraCoder/anaconda"
+	"time"
+)
+
+// Talking to a backend failed. RetryAfter is set when the backend rate
+// limited us, to how long until it lets us in again
+type FetchError struct {
+	Op         string // what was being done, like "fetch Home"
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *FetchError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s: rate limited, retrying in %s", e.Op, roundDuration(e.RetryAfter))
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// Reading or writing the database failed
+type StorageError struct {
+	Op  string
+	Err error
+}
+
+func (e *StorageError) Error() string { return e.Op + ": " + e.Err.Error() }
+func (e *StorageError) Unwrap() error { return e.Err }
+
+// An image couldn't be downloaded, decoded or cached
+type ImageError struct {
+	URL string
+	Err error
+}
+
+func (e *ImageError) Error() string { return "image " + e.URL + ": " + e.Err.Error() }
+func (e *ImageError) Unwrap() error { return e.Err }
+
+// Wraps an error from anaconda, noticing when it's twitter's rate limit
+func twitterError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	Result := &FetchError{Op: op, Err: err}
+	var apiErr *anaconda.ApiError
+	if errors.As(err, &apiErr) {
+		if limited, next := apiErr.RateLimitCheck(); limited {
+			Result.RetryAfter = time.Until(next)
+			if Result.RetryAfter <= 0 {
+				Result.RetryAfter = time.Minute
+			}
+		}
+	}
+	return Result
+}
+
+// How long to wait before the next request, 0 if the error wasn't a rate limit
+func retryAfter(err error) time.Duration {
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr.RetryAfter
+	}
+	return 0
+}
+
+// Like "4m" or "30s", for messages
+func roundDuration(d time.Duration) time.Duration {
+	if d >= time.Minute {
+		return d.Round(time.Minute)
+	}
+	return d.Round(time.Second)
+}
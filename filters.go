@@ -0,0 +1,248 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/boltdb/bolt"
+	"regexp"
+	"strings"
+)
+
+// Mute rules. Screen names and hashtags are stored without the @ or #, and
+// everything is matched case insensitively
+type Filters struct {
+	MutedUsers       []string `json:"muted_users"`
+	MutedKeywords    []string `json:"muted_keywords"`
+	MutedRegexps     []string `json:"muted_regexps"`
+	MutedHashtags    []string `json:"muted_hashtags"`
+	HideRetweetsFrom []string `json:"hide_retweets_from"`
+	HideReplies      bool     `json:"hide_replies"` // replies to other users, self-threads are still shown
+
+	regexps []*regexp.Regexp
+}
+
+func loadFilters(DB *bolt.DB) (*Filters, error) {
+	Result := &Filters{}
+	err := DB.View(func(Tx *bolt.Tx) error {
+		v := Tx.Bucket([]byte("settings")).Get([]byte("filters"))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, Result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A bad regex doesn't stop the other filters from working
+	if err := compileFilters(Result); err != nil {
+		showError("Invalid mute regex:", err)
+	}
+	return Result, nil
+}
+
+func saveFilters(DB *bolt.DB, f *Filters) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return DB.Update(func(Tx *bolt.Tx) error {
+		return Tx.Bucket([]byte("settings")).Put([]byte("filters"), data)
+	})
+}
+
+// Compiles every mute regex that compiles. The others don't mute anything,
+// and are all in the error
+func compileFilters(f *Filters) error {
+	f.regexps = f.regexps[:0]
+	var invalid []string
+	for _, expr := range f.MutedRegexps {
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		f.regexps = append(f.regexps, re)
+	}
+	if len(invalid) > 0 {
+		return errors.New(strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// A copy for another goroutine to use while these are being edited
+func copyFilters(f *Filters) *Filters {
+	Result := *f
+	Result.MutedUsers = append([]string(nil), f.MutedUsers...)
+	Result.MutedKeywords = append([]string(nil), f.MutedKeywords...)
+	Result.MutedRegexps = append([]string(nil), f.MutedRegexps...)
+	Result.MutedHashtags = append([]string(nil), f.MutedHashtags...)
+	Result.HideRetweetsFrom = append([]string(nil), f.HideRetweetsFrom...)
+	Result.regexps = append([]*regexp.Regexp(nil), f.regexps...)
+	return &Result
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFiltered(f *Filters, t *Post) bool {
+	if containsFold(f.MutedUsers, t.Author.ScreenName) {
+		return true
+	}
+
+	shown := shownPost(t)
+	if t.Reblog != nil {
+		if containsFold(f.HideRetweetsFrom, t.Author.ScreenName) {
+			return true
+		}
+		if containsFold(f.MutedUsers, shown.Author.ScreenName) {
+			return true
+		}
+	}
+
+	if f.HideReplies && shown.InReplyTo != 0 && shown.InReplyToUser != shown.Author.ID {
+		return true
+	}
+
+	text := strings.ToLower(shown.Text)
+	for _, keyword := range f.MutedKeywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	for _, re := range f.regexps {
+		if re.MatchString(shown.Text) {
+			return true
+		}
+	}
+	for _, hashtag := range postEntities(shown, HashtagEntity) {
+		if containsFold(f.MutedHashtags, hashtag) {
+			return true
+		}
+	}
+	return false
+}
+
+// The part of isFiltered that only needs the header of a stored post, so
+// posts it filters don't need decoding
+func isHeaderFiltered(f *Filters, h PostHeader) bool {
+	if containsFold(f.MutedUsers, h.ScreenName) {
+		return true
+	}
+	if h.HasReblog {
+		return containsFold(f.HideRetweetsFrom, h.ScreenName)
+	}
+	return f.HideReplies && h.InReplyTo != 0 && h.InReplyToUser != h.Author
+}
+
+func addFilterRule(list *[]string, rule string) {
+	rule = strings.TrimSpace(rule)
+	if rule == "" || containsFold(*list, rule) {
+		return
+	}
+	*list = append(*list, rule)
+}
+
+func removeFilterRule(list *[]string, i int) {
+	*list = append((*list)[:i], (*list)[i+1:]...)
+}
+
+// Marks the filters as edited, so the event loop stores them and reloads the columns
+func FiltersChanged(W *XWindow) {
+	if err := compileFilters(W.Filters); err != nil {
+		showError("Invalid mute regex:", err)
+	}
+	W.FiltersDirty = true
+}
+
+func filterRowHeight(W *XWindow) float64 {
+	return W.LineHeight + 3*currentTheme().SmallPadding
+}
+
+type filterRow struct {
+	Label  string
+	Action func()
+}
+
+func filterRows(W *XWindow) []filterRow {
+	f := W.Filters
+	var rows []filterRow
+	addList := func(list *[]string, prefix string) {
+		for i, rule := range *list {
+			i := i
+			rows = append(rows, filterRow{"✕  " + prefix + rule, func() {
+				removeFilterRule(list, i)
+				FiltersChanged(W)
+			}})
+		}
+	}
+	addList(&f.MutedUsers, "user @")
+	addList(&f.HideRetweetsFrom, "retweets by @")
+	addList(&f.MutedHashtags, "hashtag #")
+	addList(&f.MutedKeywords, "keyword ")
+	addList(&f.MutedRegexps, "regex ")
+
+	repliesLabel := "☐  Hide replies"
+	if f.HideReplies {
+		repliesLabel = "☑  Hide replies"
+	}
+	rows = append(rows, filterRow{repliesLabel, func() {
+		f.HideReplies = !f.HideReplies
+		FiltersChanged(W)
+	}})
+
+	addPrompt := func(label string, list *[]string) {
+		rows = append(rows, filterRow{"+  " + label, func() {
+			OpenPrompt(W, label+":", func(text string) {
+				if list == &f.MutedRegexps {
+					if _, err := regexp.Compile(text); err != nil {
+						showError("Invalid mute regex:", err)
+						return
+					}
+				} else if list != &f.MutedKeywords {
+					text = strings.TrimLeft(text, "@#")
+				}
+				addFilterRule(list, text)
+				FiltersChanged(W)
+			})
+		}})
+	}
+	addPrompt("Mute user", &f.MutedUsers)
+	addPrompt("Mute hashtag", &f.MutedHashtags)
+	addPrompt("Mute keyword", &f.MutedKeywords)
+	addPrompt("Mute regex", &f.MutedRegexps)
+	return rows
+}
+
+// Draws the list of mute rules. Clicking a rule removes it
+func DrawFiltersView(W *XWindow, windowWidth float64, click MouseClick) {
+	th := currentTheme()
+	rowHeight := filterRowHeight(W)
+	yPos := 10.0
+	setColor(W, th.Text)
+	DrawText(W, th.Padding, yPos, "Mute filters (F to close)")
+	yPos += rowHeight + th.Padding
+
+	for _, row := range filterRows(W) {
+		if click.Button == 1 && float64(click.Y) >= yPos && float64(click.Y) < yPos+rowHeight {
+			row.Action()
+		}
+		setColor(W, th.Card)
+		roundedRectangle(W, th.Padding, yPos, windowWidth-2*th.Padding, rowHeight-th.SmallPadding)
+		C.cairo_fill(W.Cairo)
+		setColor(W, th.Text)
+		DrawText(W, 2*th.Padding, yPos+th.SmallPadding, row.Label)
+		yPos += rowHeight
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileFiltersSkipsInvalid(t *testing.T) {
+	f := &Filters{MutedRegexps: []string{"(unclosed", "spoil(er|ers)", "[z-a]", "^breaking"}}
+	err := compileFilters(f)
+	if err == nil || !strings.Contains(err.Error(), "(unclosed") || !strings.Contains(err.Error(), "z-a") {
+		t.Errorf("Got %v, want both invalid regexps", err)
+	}
+	if len(f.regexps) != 2 {
+		t.Fatalf("Compiled %d regexps, want 2", len(f.regexps))
+	}
+	for _, text := range []string{"No SPOILERS please", "Breaking news"} {
+		if !isFiltered(f, &Post{Text: text}) {
+			t.Errorf("%q isn't muted", text)
+		}
+	}
+
+	// Compiling again, once the bad ones are fixed, doesn't keep the old ones
+	f.MutedRegexps = []string{"spoilers?"}
+	if err := compileFilters(f); err != nil || len(f.regexps) != 1 {
+		t.Errorf("Got %d regexps and %v", len(f.regexps), err)
+	}
+}
@@ -0,0 +1,19 @@
+module gowitt
+
+go 1.17
+
+require (
+	github.com/ChimeraCoder/anaconda v2.0.0+incompatible
+	github.com/boltdb/bolt v1.3.1
+	github.com/garyburd/go-oauth v0.0.0-20180319155456-bca2e7f09a17
+	github.com/godbus/dbus v4.1.0+incompatible
+)
+
+require (
+	github.com/ChimeraCoder/tokenbucket v0.0.0-20131201223612-c5a927568de7 // indirect
+	github.com/azr/backoff v0.0.0-20160115115103-53511d3c7330 // indirect
+	github.com/dustin/go-jsonpointer v0.0.0-20160814072949-ba0abeacc3dc // indirect
+	github.com/dustin/gojson v0.0.0-20160307161227-2e71ec9dd5ad // indirect
+	golang.org/x/net v0.7.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+)
@@ -5,16 +5,11 @@ TODO:
 	- Implement correct scrolling
 	- Display new tweets before replacing shortened urls, then expand urls as they arrive
 	- Stream tweets from the DB when scrolling up or down
-	- Asynchronous twitter timeline updating
 	- Do UI interaction (IMGUI-style maybe?)
 	- The image cache doesn't yet evict old images when new ones come in
-	- Add tweet time
 	- Proper error-handling everywhere
 
 Known issues:
-	- The UI freezes when images are downloaded in the background, even though system is
-	architected so it's should never block. Which I guess means it's blocking somewhere
-	inside X11...
 	- The image cache doesn't yet evict old images when new ones come in
 	- Images are never removed from the cache directory
 	- Cairo surface is resized on every redraw. I think it should only do that upon
@@ -23,15 +18,16 @@ Known issues:
 */
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/ChimeraCoder/anaconda"
 	"github.com/boltdb/bolt"
 	"net/http"
-	"net/url"
-	"strconv"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	"unsafe"
 )
@@ -39,6 +35,7 @@ import (
 /*
 #cgo pkg-config: pangocairo
 #cgo LDFLAGS: -lX11
+#include <stdlib.h>
 #include <pango/pango.h>
 #include <pango/pangocairo.h>
 #include <cairo/cairo.h>
@@ -46,27 +43,69 @@ import (
 int getXEventType(XEvent e){ return e.type; }
 XKeyEvent eventAsKeyEvent(XEvent e){ return e.xkey; }
 XButtonEvent eventAsButtonEvent(XEvent e){ return e.xbutton; }
+XMotionEvent eventAsMotionEvent(XEvent e){ return e.xmotion; }
 long clientMessageType(XEvent e) { return e.xclient.data.l[0]; }
+XSelectionRequestEvent eventAsSelectionRequest(XEvent e){ return e.xselectionrequest; }
+Atom selectionClearAtom(XEvent e){ return e.xselectionclear.selection; }
 */
 import "C"
 
-const UserImageSize = 48
-const UIPadding = 5    // pixels of padding around stuff
-const SmallPadding = 2 // pixels of smaller types of padding
-
 type XWindow struct {
 	Display *C.Display
 	Window  C.Window
 	// -- Pango
 	PangoContext *C.PangoContext
-	AttrList     *C.PangoAttrList
+	Layouts      *LayoutFactory // for tweets and messages
 	FontDesc     *C.PangoFontDescription
 	// Cairo
 	Cairo   *C.cairo_t
 	Surface *C.cairo_surface_t
 	//
-	Scroll     float64
 	UserImages *ImageCache
+	// Event loop. Only the goroutine running it touches Xlib, everything else
+	// wakes it through channels
+	Redraws     chan struct{}      // see RequestRedraw
+	XEvents     chan struct{}      // the X connection has something to read
+	xEventsRead chan struct{}      // sent by the event loop after reading them
+	stopWatch   context.CancelFunc // stops watchXConnection
+	watcher     sync.WaitGroup
+	// Active account, nil until someone logs in
+	Session    *Session
+	ScreenName string
+	// Timelines of the active account
+	Columns      []*Column
+	ActiveColumn int
+	Tabs         bool // show one column at a time, with a tab bar to switch
+	// UI state
+	TextLayout     *C.PangoLayout // for labels and other plain text
+	LineHeight     float64        // of a line of TextLayout, rows of text are sized after it
+	Menu           *ContextMenu
+	TweetMenuItems func(t *TweetInfo) []MenuItem
+	LoadGap        func(c *Column, g Gap) // fetches the missing tweets of a gap row in the background
+	// Runs work on another goroutine, and then what it returns, if not nil,
+	// on the event loop. s is the session the work is for, or nil
+	RunInBackground func(s *Session, work func() func())
+	Thread          []ThreadEntry // when not empty, shown instead of the timeline
+	ThreadScroll    float64
+	MouseX, MouseY  int
+	EventTime       C.Time // of the last key press or mouse button event
+	Tooltip         string // set while drawing by whatever is under the mouse
+	Prompt          *TextPrompt
+	// Text selection, and the text of the X selections the window owns
+	Selection *TextSelection
+	Atoms     SelectionAtoms
+	Owned     map[C.Atom]string
+	// Mute filters
+	Filters      *Filters
+	FiltersDirty bool // edited since they were last stored
+	FiltersView  bool
+	// Direct messages, shown instead of the timeline when not nil
+	DMs *DMView
+}
+
+type MouseClick struct {
+	X, Y   int
+	Button int // 0 when there was no click
 }
 
 // The scrolling system works by keeping track of what tweet is the one on the
@@ -84,17 +123,20 @@ func CreateXWindow(width, height int) (*XWindow, error) {
 	C.XInitThreads()
 
 	W := &XWindow{}
+	th := currentTheme()
 
 	W.Display = C.XOpenDisplay(nil)
 	if W.Display == nil {
 		return &XWindow{}, errors.New("Can't open display")
 	}
-	W.Window = C.XCreateSimpleWindow(W.Display, C.XDefaultRootWindow(W.Display), 0, 0, C.uint(width), C.uint(height), 0, 0, 0xFF151515)
+	W.Window = C.XCreateSimpleWindow(W.Display, C.XDefaultRootWindow(W.Display), 0, 0, C.uint(width), C.uint(height), 0, 0, C.ulong(th.Background.Pixel()))
 	C.XSetWindowBackgroundPixmap(W.Display, W.Window, 0) // This avoids flickering on resize
 	C.XMapWindow(W.Display, W.Window)
-	C.XStoreName(W.Display, W.Window, C.CString("gowitt"))
+	cName := C.CString("gowitt")
+	C.XStoreName(W.Display, W.Window, cName)
+	C.free(unsafe.Pointer(cName))
 
-	C.XSelectInput(W.Display, W.Window, C.ExposureMask|C.KeyPressMask|C.ButtonPressMask)
+	C.XSelectInput(W.Display, W.Window, C.ExposureMask|C.KeyPressMask|C.ButtonPressMask|C.ButtonReleaseMask|C.PointerMotionMask)
 	C.XFlush(W.Display)
 
 	// Cairo
@@ -103,29 +145,110 @@ func CreateXWindow(width, height int) (*XWindow, error) {
 	W.Cairo = C.cairo_create(W.Surface)
 
 	// Pango
-	InitLayoutsCache(W.Cairo)
+	W.Layouts = NewLayoutFactory(th.Font, th.EmojiFont)
 	W.PangoContext = C.pango_cairo_create_context(W.Cairo)
-	W.FontDesc = C.pango_font_description_from_string(C.CString("Sans 10"))
+	W.FontDesc = newFontDescription(th.Font, th.EmojiFont)
 
-	W.AttrList = C.pango_attr_list_new()
+	W.TextLayout = C.pango_cairo_create_layout(W.Cairo)
+	C.pango_layout_set_font_description(W.TextLayout, W.FontDesc)
+	updateLineHeight(W)
 
-	placeholderImage = C.cairo_image_surface_create_from_png(C.CString("test.png"))
+	cPlaceholder := C.CString("test.png")
+	placeholderImage = C.cairo_image_surface_create_from_png(cPlaceholder)
+	C.free(unsafe.Pointer(cPlaceholder))
+	if status := C.cairo_surface_status(placeholderImage); status != C.CAIRO_STATUS_SUCCESS {
+		logWarn("Could not load the placeholder image test.png:", C.GoString(C.cairo_status_to_string(status)))
+		C.cairo_surface_destroy(placeholderImage)
+		placeholderImage = nil
+	}
 
-	W.UserImages = NewImageCache(func() {
-		var ev C.XEvent
-		exev := (*C.XExposeEvent)(unsafe.Pointer(&ev))
-		exev._type = C.Expose
-		exev.count = 0
-		exev.window = W.Window
-		exev.send_event = 1
-		exev.display = W.Display
+	W.UserImages = NewImageCache()
+	initSelectionAtoms(W)
 
-		C.XSendEvent(W.Display, W.Window, 0, C.ExposureMask, &ev)
-		C.XFlush(W.Display)
-	})
+	W.Redraws = make(chan struct{}, 1)
+	W.XEvents = make(chan struct{})
+	W.xEventsRead = make(chan struct{}, 1)
+	var ctx context.Context
+	ctx, W.stopWatch = context.WithCancel(context.Background())
+	W.watcher.Add(1)
+	go func() {
+		defer W.watcher.Done()
+		watchXConnection(ctx, int(C.XConnectionNumber(W.Display)), W.XEvents, W.xEventsRead)
+	}()
 	return W, nil
 }
 
+// Frees everything CreateXWindow and the views made, and closes the display.
+// Sessions have to be closed before, their tweets use the window's layouts
+func DestroyXWindow(W *XWindow) {
+	W.stopWatch()
+	W.watcher.Wait()
+	CloseImageCache(W.UserImages)
+	CloseThread(W)
+	CloseDMView(W)
+	DestroyLayoutFactory(W.Layouts)
+
+	C.g_object_unref(C.gpointer(unsafe.Pointer(W.TextLayout)))
+	C.g_object_unref(C.gpointer(unsafe.Pointer(W.PangoContext)))
+	C.pango_font_description_free(W.FontDesc)
+	C.cairo_destroy(W.Cairo)
+	C.cairo_surface_destroy(W.Surface)
+	if placeholderImage != nil {
+		C.cairo_surface_destroy(placeholderImage)
+		placeholderImage = nil
+	}
+	C.XDestroyWindow(W.Display, W.Window)
+	C.XCloseDisplay(W.Display)
+}
+
+// Makes the event loop redraw the window. Safe to call from any goroutine, as
+// it doesn't talk to X
+func RequestRedraw(W *XWindow) {
+	select {
+	case W.Redraws <- struct{}{}:
+	default:
+		// A redraw is already pending
+	}
+}
+
+// Waits until the X connection has something to read, without calling Xlib, so
+// the event loop can select on it along with its channels. After each send on
+// ready it waits for the loop to read the events before looking again
+func watchXConnection(ctx context.Context, fd int, ready chan<- struct{}, read <-chan struct{}) {
+	for {
+		var fds syscall.FdSet
+		bits := 8 * int(unsafe.Sizeof(fds.Bits[0]))
+		fds.Bits[fd/bits] |= 1 << uint(fd%bits)
+		// Wakes up now and then to notice ctx was cancelled
+		timeout := syscall.NsecToTimeval(int64(200 * time.Millisecond))
+		n, err := syscall.Select(fd+1, &fds, nil, nil, &timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && err != syscall.EINTR {
+			logError("Could not wait for X events:", err)
+			if !sleepContext(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+		if n <= 0 {
+			continue
+		}
+
+		select {
+		case ready <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-read:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 var placeholderImage *C.cairo_surface_t
 
 func PixelsToPango(u float64) C.int {
@@ -143,93 +266,570 @@ func PangoRectToPixels(P *C.PangoRectangle) (x, y, w, h float64) {
 		float64(C.pango_units_to_double(P.height))
 }
 
-func RedrawWindow(W *XWindow, tweetsList []*TweetInfo, mouseClick [2]int) {
+func RedrawWindow(W *XWindow, click MouseClick) {
 	var Attribs C.XWindowAttributes
 	C.XGetWindowAttributes(W.Display, W.Window, &Attribs)
 	// TODO -- Do this only when resizing?
 	C.cairo_xlib_surface_set_size(W.Surface, Attribs.width, Attribs.height)
 
-	C.cairo_set_source_rgb(W.Cairo, 0.1, 0.1, 0.1)
+	if HandleContextMenuClick(W, click) {
+		click = MouseClick{}
+	}
+	W.Tooltip = ""
+	if W.Selection != nil {
+		W.Selection.pieces = nil
+	}
+
+	th := currentTheme()
+	setColor(W, th.Background)
 	C.cairo_paint(W.Cairo)
 
-	var Rect C.PangoRectangle
-	yPos := 10.0 + W.Scroll
+	WindowWidth := float64(Attribs.width)
+	WindowHeight := float64(Attribs.height)
 
-	WindowWidth := Attribs.width
+	if W.FiltersView {
+		DrawFiltersView(W, WindowWidth, click)
+	} else if W.DMs != nil {
+		DrawDMView(W, WindowWidth, WindowHeight, click)
+	} else if len(W.Thread) > 0 {
+		yPos := 10.0 + W.ThreadScroll
+		for _, e := range W.Thread {
+			indent := float64(e.Depth * ThreadIndent)
+			yPos += DrawTweet(W, e.Info, th.Padding+indent, yPos, WindowWidth-2*th.Padding-indent, e.Focused, click)
+		}
+	} else {
+		DrawColumns(W, WindowWidth, WindowHeight, click)
+	}
 
-	maxTweetWidth := PixelsToPango(float64(WindowWidth - 5*UIPadding - UserImageSize))
+	DrawBanners(W, WindowWidth, WindowHeight)
+	DrawContextMenu(W)
+	DrawPrompt(W, WindowWidth, WindowHeight)
+	DrawTooltip(W, WindowWidth)
+}
 
-	for i := 0; i < len(tweetsList); i++ {
-		t := tweetsList[i]
+// Lays out a tweet for the given card width, and returns where its card starts
+// relative to the tweet position, and how tall it is
+func measureTweet(t *TweetInfo, width float64) (ry, rh float64) {
+	th := currentTheme()
+	// Get tweet text size
+	_, ry, _, rh = wrapLayout(t.layouts, t.Layout, width-3*th.Padding-th.AvatarSize)
 
-		C.pango_layout_set_width(t.Layout, maxTweetWidth)
-		C.pango_layout_get_extents(t.Layout, nil, &Rect)
+	// Add padding
+	if rh < th.AvatarSize+2*th.Padding-th.Padding {
+		rh = th.AvatarSize + 2*th.Padding
+	} else {
+		rh += th.Padding
+	}
+	return ry, rh
+}
 
-		// Get tweet text size
-		_, ry, _, rh := PangoRectToPixels(&Rect)
+// Vertical space DrawTweet will take for a tweet
+func TweetHeight(t *TweetInfo, width float64) float64 {
+	_, rh := measureTweet(t, width)
+	return currentTheme().Padding + rh
+}
 
-		// Position and add padding
-		ry += yPos
-		if rh < UserImageSize+2*UIPadding-UIPadding {
-			rh = UserImageSize + 2*UIPadding
-		} else {
-			rh += UIPadding
-		}
+// Draws a tweet card at the given position, and returns the vertical space it took
+func DrawTweet(W *XWindow, t *TweetInfo, x, yPos, width float64, highlight bool, click MouseClick) float64 {
+	th := currentTheme()
+	ry, rh := measureTweet(t, width)
+	ry += yPos
 
-		// Draw rectangle around tweet
-		C.cairo_set_source_rgb(W.Cairo, 0.2, 0.2, 0.2)
-		C.cairo_rectangle(W.Cairo, UIPadding, C.double(ry), C.double(WindowWidth-2*UIPadding), C.double(rh))
-		C.cairo_fill(W.Cairo)
-		if mouseClick[0] >= UIPadding && float64(mouseClick[1]) >= ry && float64(mouseClick[1]) <= ry+rh {
-			fmt.Println("Clicked tweet", t.Text)
+	// Draw rectangle around tweet
+	if highlight {
+		setColor(W, th.CardHighlight)
+	} else {
+		setColor(W, th.Card)
+	}
+	roundedRectangle(W, x, ry, width, rh)
+	C.cairo_fill(W.Cairo)
+	if float64(click.X) >= x && float64(click.X) <= x+width && float64(click.Y) >= ry && float64(click.Y) <= ry+rh {
+		switch click.Button {
+		case 1:
+			logDebug("Clicked tweet", t.Text)
+		case 3:
+			if W.TweetMenuItems != nil {
+				OpenContextMenu(W, float64(click.X), float64(click.Y), W.TweetMenuItems(t))
+			}
 		}
+	}
 
-		// Draw user image
-		userImage := GetCachedImage(W.UserImages, t.UserImage)
-		if userImage == nil || C.cairo_surface_status(userImage) != C.CAIRO_STATUS_SUCCESS {
-			userImage = placeholderImage
+	// Right to left tweets are mirrored, avatar on the right and age on the
+	// left. Their text is aligned right by pango, see withDirection
+	avatarX, tx, ty := x+th.Padding, x+2*th.Padding+th.AvatarSize, yPos+th.SmallPadding
+	if t.RTL {
+		avatarX, tx = x+width-th.Padding-th.AvatarSize, x+th.Padding
+	}
+	drawUserImage(W, t.UserImage, avatarX, yPos+th.Padding)
+
+	// Draw tweet text, and whatever part of it is selected under it
+	if W.Menu == nil && float64(W.MouseX) >= x && float64(W.MouseX) <= x+width && float64(W.MouseY) >= ry && float64(W.MouseY) <= ry+rh {
+		trackSelection(W, t, x, tx, ty, click)
+	}
+	drawSelection(W, t, x, tx, ty)
+	C.cairo_move_to(W.Cairo, C.double(tx), C.double(ty))
+	setColor(W, th.Text)
+	showLayout(t.layouts, W.Cairo, t.Layout)
+
+	// Draw tweet age on the top right corner, with the full date as tooltip
+	if age := relativeAge(t.CreatedAt, time.Now()); age != "" {
+		ageWidth, ageHeight := TextSize(W, age)
+		ageX := x + width - th.Padding - ageWidth
+		if t.RTL {
+			ageX = x + th.Padding
+		}
+		setColor(W, th.SecondaryText)
+		DrawText(W, ageX, yPos+th.SmallPadding, age)
+		if float64(W.MouseX) >= ageX && float64(W.MouseX) <= ageX+ageWidth &&
+			float64(W.MouseY) >= yPos+th.SmallPadding && float64(W.MouseY) <= yPos+th.SmallPadding+ageHeight {
+			W.Tooltip = absoluteTime(t.CreatedAt)
 		}
-		C.cairo_set_source_surface(W.Cairo, userImage, 2*UIPadding, C.double(yPos+UIPadding))
-		C.cairo_paint(W.Cairo)
+	}
+	return th.Padding + rh
+}
+
+// Draws an avatar from the image cache, or the placeholder while it's
+// downloaded, scaled to the theme's avatar size
+func drawUserImage(W *XWindow, URL string, x, y float64) {
+	userImage := GetCachedImage(W.UserImages, URL)
+	if userImage == nil || C.cairo_surface_status(userImage) != C.CAIRO_STATUS_SUCCESS {
+		userImage = placeholderImage
+	}
+	th := currentTheme()
+	size := th.AvatarSize
+	// Without even the placeholder there's only a square where the image goes
+	if userImage == nil {
+		setColor(W, th.SecondaryText)
+		C.cairo_rectangle(W.Cairo, C.double(x), C.double(y), C.double(size), C.double(size))
+		C.cairo_fill(W.Cairo)
+		return
+	}
+	imageWidth := float64(C.cairo_image_surface_get_width(userImage))
+	C.cairo_save(W.Cairo)
+	C.cairo_translate(W.Cairo, C.double(x), C.double(y))
+	if imageWidth > 0 && imageWidth != size {
+		C.cairo_scale(W.Cairo, C.double(size/imageWidth), C.double(size/imageWidth))
+	}
+	C.cairo_set_source_surface(W.Cairo, userImage, 0, 0)
+	C.cairo_paint(W.Cairo)
+	C.cairo_restore(W.Cairo)
+}
 
-		// Draw tweet text
-		C.cairo_move_to(W.Cairo, 63, C.double(yPos+SmallPadding))
-		C.cairo_set_source_rgb(W.Cairo, 0.95, 0.95, 0.95)
-		C.pango_cairo_show_layout(W.Cairo, t.Layout)
-		yPos += 5 + rh
+// Scrolls whatever view is currently shown. column is only used by the timeline view
+func ScrollView(W *XWindow, column int, delta float64) {
+	if W.DMs != nil {
+		W.DMs.Scroll += delta
+	} else if len(W.Thread) > 0 {
+		W.ThreadScroll += delta
+	} else if column < len(W.Columns) {
+		W.Columns[column].Scroll += delta
 	}
 }
 
+func SetWindowTitle(W *XWindow, title string) {
+	cTitle := C.CString(title)
+	C.XStoreName(W.Display, W.Window, cTitle)
+	C.free(unsafe.Pointer(cTitle))
+	C.XFlush(W.Display)
+}
+
+func windowWidth(W *XWindow) float64 {
+	var Attribs C.XWindowAttributes
+	C.XGetWindowAttributes(W.Display, W.Window, &Attribs)
+	return float64(Attribs.width)
+}
+
+func CloseThread(W *XWindow) {
+	DestroyThread(W.Thread)
+	W.Thread = nil
+	W.ThreadScroll = 0
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := runUI(); err != nil {
+		logError(err)
+		os.Exit(1)
+	}
+}
 
-	window, err := CreateXWindow(500, 500)
+// Opens the window, what gowitt does when run without a command
+func runUI() error {
+	config, err := loadConfig()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := openLogFile(parseLogLevel(config.LogLevel)); err != nil {
+		logWarn("Could not open the log file:", err)
+	}
+	defer closeLogFile()
+	if theme, err := loadConfigTheme(config); err != nil {
+		showError("Could not load the theme:", err)
+	} else {
+		setTheme(theme)
 	}
 
-	defer C.XCloseDisplay(window.Display)
+	// Cancelled on the way out, stopping everything running in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	DB, err := initDB()
+	window, err := CreateXWindow(500, 500)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	//getTwitterData(DB)
-	tweetsList, err := regenerateViewData(window, DB, 20)
+	defer DestroyXWindow(window)
+	window.Tabs = config.Layout == "tabs"
+
+	banners.Lock()
+	banners.Changed = func() { RequestRedraw(window) }
+	banners.Unlock()
+	defer func() {
+		banners.Lock()
+		banners.Changed = nil
+		banners.Unlock()
+	}()
+
+	credentials, err := loadCredentials()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if currentAccount(credentials) == nil && stdinIsTerminal() {
+		if _, err = loginInTerminal(credentials); err != nil {
+			return err
+		}
+	}
+
+	// Every account polls in the background, switching only changes which
+	// one is shown. Sessions go by accountKey
+	sessions := make(map[string]*Session)
+	updatedTimelines := make(chan TimelineUpdate, 16)
+	switchAccount := func(account *Account) {
+		// Logging in again gives the account new tokens its session doesn't have
+		for key, s := range sessions {
+			if sameAccount(&s.Account, account) && s.Account != *account {
+				if s == window.Session {
+					flushSession(window)
+					window.Session = nil
+					window.Columns = nil
+				}
+				CloseSession(s)
+				delete(sessions, key)
+			}
+		}
+		s := sessions[accountKey(account)]
+		if s == nil {
+			var err error
+			if s, err = OpenSession(ctx, window, credentials, account, config, updatedTimelines); err != nil {
+				showError("Could not open account @"+account.ScreenName, err)
+				return
+			}
+			sessions[accountKey(account)] = s
+		}
+		ActivateSession(window, s)
+	}
+	defer func() {
+		flushSession(window)
+		for _, s := range sessions {
+			CloseSession(s)
+		}
+	}()
+
+	// Columns are reloaded on worker goroutines, the event loop only swaps
+	// the prepared tweets in. They use the databases, so they're stopped
+	// before the sessions close
+	prepared := make(chan *PreparedColumn)
+	var workers sync.WaitGroup
+	defer func() {
+		cancel()
+		workers.Wait()
+	}()
+	// Anything else that waits for the network, the event loop runs what it
+	// returns. Work for a session stops with it
+	finished := make(chan func())
+	window.RunInBackground = func(s *Session, work func() func()) {
+		group, ctx := &workers, ctx
+		if s != nil {
+			// A prompt can outlive its session
+			if s.ctx.Err() != nil {
+				return
+			}
+			group, ctx = &s.workers, s.ctx
+		}
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			done := work()
+			if done == nil {
+				return
+			}
+			select {
+			case finished <- done:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	for i := range credentials.Accounts {
+		account := &credentials.Accounts[i]
+		s, err := OpenSession(ctx, window, credentials, account, config, updatedTimelines)
+		if err != nil {
+			// switchAccount tries again if it's picked
+			showError("Could not open account @"+account.ScreenName, err)
+			continue
+		}
+		sessions[accountKey(account)] = s
+	}
+	if account := currentAccount(credentials); account != nil {
+		switchAccount(account)
+	} else {
+		LoginInWindow(window, credentials, switchAccount)
+	}
+
+	// The menu stays open across reloads, which free t, so the items only
+	// keep copies of what they need
+	window.TweetMenuItems = func(t *TweetInfo) []MenuItem {
+		s, ID := window.Session, t.ID
+		items := []MenuItem{
+			{"View conversation", func() {
+				window.RunInBackground(s, func() func() {
+					// Missing posts are fetched, and laid out here too
+					thread, err := buildThread(window, s.DB, s.Backend, ID)
+					if err != nil {
+						showError("Could not build conversation:", err)
+						return nil
+					}
+					return func() {
+						if window.Session != s {
+							DestroyThread(thread)
+							return
+						}
+						CloseThread(window)
+						window.Thread = thread
+					}
+				})
+			}},
+		}
+
+		backend, target := s.Backend, actionTarget(t)
+		items = append(items, MenuItem{"Favourite", func() {
+			window.RunInBackground(s, func() func() {
+				if err := favouritePost(backend, target); err != nil {
+					showError(err)
+				}
+				return nil
+			})
+		}})
+		items = append(items, MenuItem{"Boost", func() {
+			window.RunInBackground(s, func() func() {
+				if err := boostPost(backend, target); err != nil {
+					showError(err)
+				}
+				return nil
+			})
+		}})
+		items = append(items, MenuItem{"Reply…", func() {
+			OpenPrompt(window, "Reply to @"+target.Author.ScreenName+":", func(text string) {
+				window.RunInBackground(s, func() func() {
+					if err := replyToPost(backend, target, text); err != nil {
+						showError(err)
+					}
+					return nil
+				})
+			})
+		}})
+
+		text, link := t.Text, backend.PostURL(t.ScreenName, t.ShownID)
+		items = append(items, MenuItem{"Copy text", func() {
+			CopyText(window, text)
+		}})
+		items = append(items, MenuItem{"Copy link to tweet", func() {
+			CopyText(window, link)
+		}})
+
+		screenName := t.ScreenName
+		items = append(items, MenuItem{"Mute @" + screenName, func() {
+			muteUser(window.Filters, screenName)
+			FiltersChanged(window)
+		}})
+		if retweeter := t.RetweetedBy; retweeter != "" {
+			items = append(items, MenuItem{"Hide retweets by @" + retweeter, func() {
+				addFilterRule(&window.Filters.HideRetweetsFrom, retweeter)
+				FiltersChanged(window)
+			}})
+		}
+		for _, hashtag := range t.Hashtags {
+			hashtag := hashtag
+			items = append(items, MenuItem{"Mute #" + hashtag, func() {
+				addFilterRule(&window.Filters.MutedHashtags, hashtag)
+				FiltersChanged(window)
+			}})
+		}
+		return items
+	}
+
+	timestamps := time.NewTicker(TimestampRefreshInterval)
+	defer timestamps.Stop()
+	// Quit like closing the window does, so everything gets saved
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	applyPrepared := func(p *PreparedColumn) {
+		if err := applyPreparedColumn(p); err != nil {
+			showError("Could not reload", timelineTitle(p.Column.Source), err)
+		}
+		UpdateWindowTitle(window)
+	}
+
+	// Reloads what shows a timeline the poller stored into
+	applyUpdate := func(update TimelineUpdate) {
+		s := sessions[update.Account]
+		if s == nil {
+			return
+		}
+		if update.Timeline == DMTimelineKey {
+			if s == window.Session {
+				if err := ReloadDMView(window); err != nil {
+					showError("Could not reload direct messages:", err)
+				}
+			}
+			UpdateWindowTitle(window)
+			return
+		}
+		for _, c := range s.Columns {
+			if timelineKey(c.Source) != update.Timeline {
+				continue
+			}
+			c.generation++
+			c, generation, filters := c, c.generation, copyFilters(s.Filters)
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				p, err := prepareColumn(window, s.DB, filters, c, generation)
+				if err != nil {
+					showError("Could not reload", update.Timeline, err)
+					return
+				}
+				select {
+				case prepared <- p:
+				case <-ctx.Done():
+					discardPreparedColumn(p)
+				}
+			}()
+		}
+	}
+
+	// Lays everything out again, with the new colors and font
+	themeChanges := make(chan *Theme)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		watchTheme(ctx, config.Theme, themeChanges)
+	}()
+	applyTheme := func(t *Theme) {
+		ApplyTheme(window, t)
+		for _, s := range sessions {
+			for _, c := range s.Columns {
+				if err := ReloadColumn(window, s.DB, s.Filters, c); err != nil {
+					showError("Could not reload", timelineTitle(c.Source), err)
+				}
+			}
+		}
+		if err := ReloadDMView(window); err != nil {
+			showError("Could not reload direct messages:", err)
+		}
+		for _, e := range window.Thread {
+			if !e.Focused {
+				continue
+			}
+			// Everything in it was fetched when it was opened
+			thread, err := buildThread(window, window.Session.DB, nil, e.Info.ID)
+			if err != nil {
+				showError("Could not reload conversation:", err)
+				break
+			}
+			scroll := window.ThreadScroll
+			CloseThread(window)
+			window.Thread, window.ThreadScroll = thread, scroll
+			break
+		}
 	}
 
-	wmDeleteMessage := C.XInternAtom(window.Display, C.CString("WM_DELETE_WINDOW"), 0)
+	// The tweets of the gap go in between the ones shown, without a reload
+	window.LoadGap = func(c *Column, g Gap) {
+		s := window.Session
+		filters := copyFilters(s.Filters)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			posts, err := fillGap(s.DB, s.Backend, c.Source, g)
+			var p *PreparedColumn
+			if err == nil {
+				p, err = prepareGapFill(window, s.DB, filters, c, posts)
+			}
+			if err != nil {
+				showError("Could not load missing tweets:", err)
+				// Reloading the column clears its LoadingGap
+				sendUpdate(ctx, updatedTimelines, TimelineUpdate{accountKey(&s.Account), timelineKey(c.Source)})
+				return
+			}
+			select {
+			case prepared <- p:
+			case <-ctx.Done():
+				discardPreparedColumn(p)
+			}
+		}()
+	}
+
+	cAtom := C.CString("WM_DELETE_WINDOW")
+	wmDeleteMessage := C.XInternAtom(window.Display, cAtom, 0)
+	C.free(unsafe.Pointer(cAtom))
 	C.XSetWMProtocols(window.Display, window.Window, &wmDeleteMessage, 1)
-	mouseClick := [2]int{-1, -1}
+	var mouseClick MouseClick
 	var event C.XEvent
 	for {
 		pendingRedraws := false
-		processedOneEvent := false
-		for !processedOneEvent || C.XPending(window.Display) != 0 {
+		selectionReleased := false
+		// Xlib reads more than it's asked for, and events it already queued
+		// don't make the connection readable. So only wait when there are none
+		xEvents := false
+		if C.XPending(window.Display) == 0 {
+			select {
+			case <-window.XEvents:
+				xEvents = true
+			case <-window.Redraws:
+				pendingRedraws = true
+			case update := <-updatedTimelines:
+				applyUpdate(update)
+				pendingRedraws = true
+			case p := <-prepared:
+				applyPrepared(p)
+				pendingRedraws = true
+			case done := <-finished:
+				done()
+				pendingRedraws = true
+			case info := <-window.UserImages.Downloads:
+				addCachedImage(window.UserImages, info)
+				pendingRedraws = true
+			case <-timestamps.C:
+				pendingRedraws = true
+			case t := <-themeChanges:
+				applyTheme(t)
+				pendingRedraws = true
+			case <-signals:
+				return nil
+			}
+		}
+
+		for C.XPending(window.Display) != 0 {
 			C.XNextEvent(window.Display, &event)
-			processedOneEvent = true
 
 			switch C.getXEventType(event) {
 			case C.Expose:
@@ -237,82 +837,187 @@ func main() {
 			case C.KeyPress:
 				ke := C.eventAsKeyEvent(event)
 				//fmt.Println("Key pressed", ke.keycode)
+				window.EventTime = ke.time
+				pendingRedraws = true
+				if HandlePromptKey(window, &ke) {
+					break
+				}
 				switch ke.keycode {
 				case 116: // down
-					window.Scroll -= 10
+					ScrollView(window, window.ActiveColumn, -10)
 				case 111: // up
-					window.Scroll += 10
+					ScrollView(window, window.ActiveColumn, 10)
+				case 113: // left
+					if window.ActiveColumn > 0 {
+						window.ActiveColumn--
+					}
+				case 114: // right
+					if window.ActiveColumn < len(window.Columns)-1 {
+						window.ActiveColumn++
+					}
+				case 28: // t
+					window.Tabs = !window.Tabs
+					config.Layout = "columns"
+					if window.Tabs {
+						config.Layout = "tabs"
+					}
+					if err := saveConfig(config); err != nil {
+						showError("Could not save config:", err)
+					}
+				case 41: // f
+					window.FiltersView = !window.FiltersView
+				case 40: // d
+					if window.DMs != nil {
+						CloseDMView(window)
+					} else if window.Session == nil {
+						postBanner("Log in to see direct messages")
+					} else if err := OpenDMView(window, window.Session.DB, window.Session.API); err != nil {
+						showError("Could not open direct messages:", err)
+					}
+				case 27: // r
+					ComposeDM(window)
+				case 38: // a
+					OpenContextMenu(window, float64(window.MouseX), float64(window.MouseY), AccountMenuItems(window, credentials, switchAccount))
+				case 54: // c
+					CopyText(window, selectionText(window))
+				case 30: // u
+					if window.ActiveColumn < len(window.Columns) {
+						JumpToOldestUnread(window.Columns[window.ActiveColumn])
+					}
+				case 9: // escape
+					if window.Menu != nil {
+						window.Menu = nil
+					} else if window.FiltersView {
+						window.FiltersView = false
+					} else if window.DMs != nil && window.DMs.Open != nil {
+						closeDMConversation(window.DMs)
+					} else if window.DMs != nil {
+						CloseDMView(window)
+					} else {
+						CloseThread(window)
+					}
 				}
-				pendingRedraws = true
 			case C.ButtonPress:
 				b := C.eventAsButtonEvent(event)
+				window.EventTime = b.time
 				switch b.button {
 				case 4: // scroll up
-					window.Scroll += 10
+					ScrollView(window, columnAt(window, int(b.x), windowWidth(window)), 10)
 				case 5: // scroll down
-					window.Scroll -= 10
-				case 1:
-					// left mouse down
+					ScrollView(window, columnAt(window, int(b.x), windowWidth(window)), -10)
+				case 1, 3:
+					// left or right mouse down
 					butEv := (*C.XButtonEvent)(unsafe.Pointer(&event))
-					mouseClick[0] = int(butEv.x)
-					mouseClick[1] = int(butEv.y)
+					mouseClick = MouseClick{int(butEv.x), int(butEv.y), int(b.button)}
+					window.MouseX = int(butEv.x)
+					window.MouseY = int(butEv.y)
+				}
+				pendingRedraws = true
+			case C.ButtonRelease:
+				b := C.eventAsButtonEvent(event)
+				window.EventTime = b.time
+				if b.button == 1 {
+					selectionReleased = true
+					pendingRedraws = true
 				}
+			case C.SelectionRequest:
+				req := C.eventAsSelectionRequest(event)
+				AnswerSelectionRequest(window, &req)
+			case C.SelectionClear:
+				SelectionCleared(window, C.selectionClearAtom(event))
+				pendingRedraws = true
+			case C.MotionNotify:
+				m := C.eventAsMotionEvent(event)
+				window.MouseX = int(m.x)
+				window.MouseY = int(m.y)
 				pendingRedraws = true
 			case C.ClientMessage:
 				if C.clientMessageType(event) == C.long(wmDeleteMessage) {
-					return
+					return nil
 				}
 			}
 		}
+
+		if xEvents {
+			window.xEventsRead <- struct{}{}
+		}
+
+		// Take whatever else is waiting, so it all makes a single redraw
+	drain:
+		for {
+			select {
+			case update := <-updatedTimelines:
+				applyUpdate(update)
+				pendingRedraws = true
+			case p := <-prepared:
+				applyPrepared(p)
+				pendingRedraws = true
+			case done := <-finished:
+				done()
+				pendingRedraws = true
+			case info := <-window.UserImages.Downloads:
+				addCachedImage(window.UserImages, info)
+				pendingRedraws = true
+			case <-window.Redraws:
+				pendingRedraws = true
+			default:
+				break drain
+			}
+		}
+
 		if pendingRedraws {
-			RedrawWindow(window, tweetsList, mouseClick)
-			mouseClick[0] = -1
-			mouseClick[1] = -1
+			RedrawWindow(window, mouseClick)
+			mouseClick = MouseClick{}
+			if selectionReleased {
+				FinishSelection(window)
+			}
+			if window.Session == nil {
+				continue
+			}
+			if err := SaveReadMarkers(window, window.Session.DB); err != nil {
+				showError("Could not save read markers:", err)
+			}
+
+			if window.FiltersDirty {
+				window.FiltersDirty = false
+				if err := applyFilters(window, window.Session.DB, window.Filters, window.Columns); err != nil {
+					showError(err)
+				}
+				UpdateWindowTitle(window)
+				RedrawWindow(window, MouseClick{})
+			}
 		}
 	}
 }
 
-func regenerateViewData(W *XWindow, DB *bolt.DB, MaxTweets int) ([]*TweetInfo, error) {
-	tweets, err := getLastNTweets(DB, MaxTweets)
-	if err != nil {
-		return []*TweetInfo{}, err
-	}
-	var Result []*TweetInfo
-	for i := range tweets {
-		Result = append(Result, GenerateTweetInfo(W, &tweets[i]))
-	}
-	return Result, nil
+// Fetches the newest posts of a timeline into the database, and returns the
+// ones that weren't stored before
+func getTimelineData(DB *bolt.DB, backend Backend, source TimelineSource) ([]Post, error) {
+	return fetchTimelineRange(DB, backend, source, 0, 0, 10)
 }
 
-func getTwitterData(DB *bolt.DB) {
-	anaconda.SetConsumerKey("KmxA5PMS1WaVdSnJrYtq5XANb")
-	anaconda.SetConsumerSecret("yt7ydv2qFt7BpyHrMK3UzIj7HXGGv7ezcVTnELxhgh2WMGj9IA")
-	api := anaconda.NewTwitterApi(
-		"268263175-deYL6a9YyDMy8YRDQI0p9NDBoKuZScRKG24Dpqkj",
-		"PrFnSYOzsZjPYc5zhN9qeviyyHH0x1sKkiOYSSyPdWrnS")
-
-	tweets, err := api.GetHomeTimeline(url.Values{
-		"count": {"10"},
-	})
+// Like getTimelineData, for the posts newer than sinceID and not newer than
+// maxID. Either can be 0 for no limit. Records what was fetched, see ranges.go
+func fetchTimelineRange(DB *bolt.DB, backend Backend, source TimelineSource, sinceID, maxID int64, count int) ([]Post, error) {
+	posts, err := backend.Timeline(source, sinceID, maxID, count)
 	if err != nil {
-		// TODO -- Handle timeouts here
-		panic(err)
+		return nil, err
 	}
 
+	op := "store " + timelineTitle(source)
 	Tx, err := DB.Begin(true)
 	if err != nil {
-		// TODO -- Handle this gracely
-		panic(err)
+		return nil, &StorageError{op, err}
 	}
-	Bucket := Tx.Bucket([]byte("tweets"))
-	for _, t := range tweets {
-
-		tweetText := t.Text
-		if t.RetweetedStatus != nil {
-			tweetText = t.RetweetedStatus.Text
+	var newPosts []Post
+	for _, p := range posts {
+		if Tx.Bucket([]byte("tweets")).Get(tweetKey(p.ID)) == nil {
+			newPosts = append(newPosts, p)
 		}
-		tweetText = replaceURLS(tweetText, func(s string) string {
-			fmt.Println("Replacing ", s)
+
+		shown := shownPost(&p)
+		shown.Text = replaceURLS(shown.Text, func(s string) string {
+			logDebug("Replacing ", s)
 			for retries := 0; retries < 3; retries++ {
 				newS, err := getRedirectedURL(s)
 				if err != nil {
@@ -323,25 +1028,25 @@ func getTwitterData(DB *bolt.DB) {
 			}
 			return s
 		})
-		if t.RetweetedStatus != nil {
-			t.RetweetedStatus.Text = tweetText
-		} else {
-			t.Text = tweetText
+		if err = storePost(Tx, &p); err != nil {
+			Tx.Rollback()
+			return nil, &StorageError{op, err}
 		}
-		data, err := json.Marshal(t)
-		if err != nil {
+		if err = addToTimeline(Tx, timelineKey(source), p.ID); err != nil {
 			Tx.Rollback()
-			DB.Sync()
-			panic(err)
+			return nil, &StorageError{op, err}
 		}
-		key := []byte(strconv.FormatInt(t.Id, 16))
-		if err = Bucket.Put(key, data); err != nil {
+	}
+	if r, ok := fetchedRange(posts, sinceID, maxID, count); ok {
+		if err = addFetchedRange(Tx, timelineKey(source), r); err != nil {
 			Tx.Rollback()
-			DB.Sync()
-			panic(err)
+			return nil, &StorageError{op, err}
 		}
 	}
-	Tx.Commit()
+	if err := Tx.Commit(); err != nil {
+		return nil, &StorageError{op, err}
+	}
+	return newPosts, nil
 }
 
 // Auxiliary function to get original URLs from URL shorteners
@@ -0,0 +1,58 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <stdlib.h>
+#include <pango/pango.h>
+#include <pango/pangocairo.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// A window drawing into an image in memory, with no X display, for tests.
+// Only the drawing code can use it, and avatars are never downloaded
+func NewImageWindow(width, height int) *XWindow {
+	W := &XWindow{}
+	th := currentTheme()
+
+	W.Surface = C.cairo_image_surface_create(C.CAIRO_FORMAT_ARGB32, C.int(width), C.int(height))
+	W.Cairo = C.cairo_create(W.Surface)
+	setColor(W, th.Background)
+	C.cairo_paint(W.Cairo)
+
+	W.Layouts = NewLayoutFactory(th.Font, th.EmojiFont)
+	W.PangoContext = C.pango_cairo_create_context(W.Cairo)
+	W.FontDesc = newFontDescription(th.Font, th.EmojiFont)
+	W.TextLayout = C.pango_cairo_create_layout(W.Cairo)
+	C.pango_layout_set_font_description(W.TextLayout, W.FontDesc)
+	updateLineHeight(W)
+
+	W.UserImages = newImageCacheMaps()
+	return W
+}
+
+// Frees what NewImageWindow made. Tweets laid out for it have to be destroyed before
+func DestroyImageWindow(W *XWindow) {
+	DestroyLayoutFactory(W.Layouts)
+	C.g_object_unref(C.gpointer(unsafe.Pointer(W.TextLayout)))
+	C.g_object_unref(C.gpointer(unsafe.Pointer(W.PangoContext)))
+	C.pango_font_description_free(W.FontDesc)
+	C.cairo_destroy(W.Cairo)
+	C.cairo_surface_destroy(W.Surface)
+}
+
+// Saves what was drawn as a png
+func WriteImageWindow(W *XWindow, path string) error {
+	C.cairo_surface_flush(W.Surface)
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	if status := C.cairo_surface_write_to_png(W.Surface, cPath); status != C.CAIRO_STATUS_SUCCESS {
+		return errors.New(path + ": " + C.GoString(C.cairo_status_to_string(status)))
+	}
+	return nil
+}
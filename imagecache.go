@@ -8,15 +8,17 @@ import "C"
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
-	"fmt"
+	"errors"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +28,13 @@ import _ "image/jpeg"
 
 const DownloadGoroutines = 3
 
+// How long a URL that couldn't be downloaded is left alone. It doubles with
+// each failure, up to MaxImageRetryDelay
+const (
+	ImageRetryDelay    = time.Minute
+	MaxImageRetryDelay = 6 * time.Hour
+)
+
 type CacheNode struct {
 	LastUsed    int64
 	Img         *C.cairo_surface_t
@@ -38,15 +47,27 @@ type ImageInfo struct {
 	Filename    string
 	Img         *C.cairo_surface_t
 	imgInternal image.Image
+	Err         error // the image couldn't be had, Img is nil
+}
+
+// An image that couldn't be downloaded, and when to try again
+type imageFailure struct {
+	Count   int
+	RetryAt time.Time
 }
 
 type ImageCache struct {
 	sync.Mutex
-	Cache map[string]CacheNode
+	Cache   map[string]CacheNode
+	pending map[string]bool // asked for, and not back from the downloaders yet
+	failed  map[string]imageFailure
 
-	URLRequests        chan string
-	Downloads          chan ImageInfo
-	ImageAddedCallback func()
+	URLRequests chan string
+	Downloads   chan ImageInfo // read by the event loop, which adds them with addCachedImage
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
 }
 
 func loadImage(path string) (image.Image, error) {
@@ -81,145 +102,269 @@ func loadCairoImage(img image.Image) *C.cairo_surface_t {
 		C.int(img.Bounds().Dx()), C.int(img.Bounds().Dy()), C.int(i.Stride))
 
 	if C.cairo_surface_status(loadedImage) != C.CAIRO_STATUS_SUCCESS {
-		fmt.Println("COuld not create cairo image", C.GoString(C.cairo_status_to_string(C.cairo_surface_status(loadedImage))))
+		logError("COuld not create cairo image", C.GoString(C.cairo_status_to_string(C.cairo_surface_status(loadedImage))))
 		return nil
 	}
 	return loadedImage
 }
 
-func imageDownloader(URLs <-chan string, files chan<- ImageInfo) {
-	for {
-		URL := <-URLs
+// Waits for d, returns false if ctx was cancelled meanwhile
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
 
-		info := ImageInfo{
-			URL:      URL,
-			Filename: URLToFilename(URL),
-			Img:      nil,
+func imageDownloader(ctx context.Context, URLs <-chan string, files chan<- ImageInfo) {
+	for {
+		var URL string
+		select {
+		case <-ctx.Done():
+			return
+		case URL = <-URLs:
 		}
 
-		// Check hard drive
-		img, err := loadImage(info.Filename)
-		if err == nil {
-			info.Img = loadCairoImage(img)
-			info.imgInternal = img
-			files <- info
-			continue
+		info, ok := fetchImage(ctx, URL)
+		if !ok {
+			return
 		}
-
-		fmt.Println("Downloading", info.URL)
-		delay := time.Second / 2
-		retriesLeft := 3
-	retry:
-		delay *= 2
-		if retriesLeft == 0 {
-			// TODO -log failure
-			fmt.Println("Retries exhausted trying to download", info.URL)
-			continue
+		if info.Err != nil {
+			logWarn(&ImageError{info.URL, info.Err})
 		}
-		retriesLeft--
-		resp, err := http.Get(info.URL)
-		if err != nil {
-			time.Sleep(delay)
-			goto retry
+		select {
+		case files <- info:
+		case <-ctx.Done():
+			if info.Img != nil {
+				C.cairo_surface_destroy(info.Img)
+			}
+			return
 		}
+	}
+}
 
-		// Download image to buffer
-		var buf bytes.Buffer
+// Loads an image from the cache directory, or downloads it there. Returns
+// false if ctx was cancelled meanwhile
+func fetchImage(ctx context.Context, URL string) (ImageInfo, bool) {
+	info := ImageInfo{
+		URL:      URL,
+		Filename: URLToFilename(URL),
+		Img:      nil,
+	}
 
-		_, err = io.Copy(&buf, resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			time.Sleep(delay)
-			fmt.Println("error downloading image")
-			goto retry
+	// Check hard drive
+	img, err := loadImage(info.Filename)
+	if err == nil {
+		// gowitt gc deletes the images that weren't used for a while
+		now := time.Now()
+		os.Chtimes(info.Filename, now, now)
+		info.Img = loadCairoImage(img)
+		info.imgInternal = img
+		if info.Img == nil {
+			info.Err = errors.New("cairo can't use it")
 		}
+		return info, true
+	}
 
-		// Make sure it's a png image
-		img, _, err = image.Decode(&buf)
-		if err != nil {
-			fmt.Println("error decoding image")
-			time.Sleep(delay)
-			goto retry
+	logDebug("Downloading", info.URL)
+	delay := time.Second / 2
+	retriesLeft := 3
+retry:
+	delay *= 2
+	if retriesLeft == 0 {
+		info.Err = errors.New("retries exhausted")
+		return info, true
+	}
+	retriesLeft--
+	req, err := http.NewRequestWithContext(ctx, "GET", info.URL, nil)
+	if err != nil {
+		info.Err = err
+		return info, true
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil || !sleepContext(ctx, delay) {
+			return info, false
 		}
+		goto retry
+	}
+
+	// Download image to buffer
+	var buf bytes.Buffer
 
-		// Save image to disk
-		file, err := os.Create(info.Filename)
-		if err != nil {
-			panic(err) // TODO -- handle this gracefully
+	_, err = io.Copy(&buf, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logDebug("error downloading image")
+		if !sleepContext(ctx, delay) {
+			return info, false
 		}
+		goto retry
+	}
 
-		if err := png.Encode(file, img); err != nil {
-			panic(err) // TODO -- handle this gracefully
+	// Make sure it's a png image
+	img, _, err = image.Decode(&buf)
+	if err != nil {
+		logDebug("error decoding image")
+		if !sleepContext(ctx, delay) {
+			return info, false
 		}
+		goto retry
+	}
 
-		file.Close()
+	if err := saveImage(info.Filename, img); err != nil {
+		info.Err = err
+		return info, true
+	}
+	img, err = loadImage(info.Filename)
+	if err != nil {
+		info.Err = err
+		return info, true
+	}
+	info.Img = loadCairoImage(img)
+	info.imgInternal = img
+	if info.Img == nil {
+		info.Err = errors.New("cairo can't use it")
+	}
+	return info, true
+}
 
-		img, err = loadImage(info.Filename)
-		if err != nil {
-			panic("Can't load png image" + info.Filename) // TODO -- do something
-		}
-		info.Img = loadCairoImage(img)
-		info.imgInternal = img
-		files <- info
+// Stores a downloaded image in the cache directory as a png
+func saveImage(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
 	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
 }
 
-func imageAdder(ic *ImageCache) {
-	for {
-		info := <-ic.Downloads
-
-		ic.Lock()
-		ic.Cache[info.URL] = CacheNode{
-			LastUsed:    0,
-			Img:         info.Img,
-			imgInternal: info.imgInternal,
-			Filename:    info.Filename,
+// Takes what the downloaders sent back. Failures are left alone for a while
+// instead of being asked for again on every redraw
+func addCachedImage(ic *ImageCache, info ImageInfo) {
+	ic.Lock()
+	defer ic.Unlock()
+	delete(ic.pending, info.URL)
+	if info.Err != nil {
+		f := ic.failed[info.URL]
+		delay := ImageRetryDelay << uint(f.Count)
+		if delay > MaxImageRetryDelay || delay <= 0 {
+			delay = MaxImageRetryDelay
 		}
-		ic.Unlock()
+		ic.failed[info.URL] = imageFailure{f.Count + 1, time.Now().Add(delay)}
+		return
+	}
+	delete(ic.failed, info.URL)
+
+	if old, ok := ic.Cache[info.URL]; ok && old.Img != nil && old.Img != info.Img {
+		C.cairo_surface_destroy(old.Img)
+	}
+	ic.Cache[info.URL] = CacheNode{
+		LastUsed:    0,
+		Img:         info.Img,
+		imgInternal: info.imgInternal,
+		Filename:    info.Filename,
+	}
+	// TODO -- keep track of when was each image used
+	// TODO -- remove oldest-used images when cache fills up
+}
 
-		ic.ImageAddedCallback()
-		// TODO -- keep track of when was each image used
-		// TODO -- remove oldest-used images when cache fills up
+func cacheDir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
 	}
+	return filepath.Join(dir, "gowitt")
+}
+
+func imageCacheDir() string {
+	return filepath.Join(cacheDir(), "images")
 }
 
-func NewImageCache(imageAddedCallback func()) *ImageCache {
+func NewImageCache() *ImageCache {
+	if err := os.MkdirAll(imageCacheDir(), 0700); err != nil {
+		logError("Could not create the image cache:", err)
+	}
 
-	var Result ImageCache
-	Result.URLRequests = make(chan string, 20)
-	Result.Downloads = make(chan ImageInfo, 20)
-	Result.Cache = make(map[string]CacheNode)
-	Result.ImageAddedCallback = imageAddedCallback
+	Result := newImageCacheMaps()
+	Result.ctx, Result.cancel = context.WithCancel(context.Background())
 
+	Result.workers.Add(DownloadGoroutines)
 	for i := 0; i < DownloadGoroutines; i++ {
-		go imageDownloader(Result.URLRequests, Result.Downloads)
+		go func() {
+			defer Result.workers.Done()
+			imageDownloader(Result.ctx, Result.URLRequests, Result.Downloads)
+		}()
 	}
 
-	go imageAdder(&Result)
+	return Result
+}
 
-	return &Result
+// An image cache with nobody downloading, requests pile up in URLRequests
+func newImageCacheMaps() *ImageCache {
+	return &ImageCache{
+		Cache:       make(map[string]CacheNode),
+		pending:     make(map[string]bool),
+		failed:      make(map[string]imageFailure),
+		URLRequests: make(chan string, 20),
+		Downloads:   make(chan ImageInfo, 20),
+	}
+}
+
+// Stops the downloads and frees every cached image
+func CloseImageCache(ic *ImageCache) {
+	ic.cancel()
+	ic.workers.Wait()
+
+	// Downloaded but never picked up by the event loop
+	for len(ic.Downloads) > 0 {
+		if info := <-ic.Downloads; info.Img != nil {
+			C.cairo_surface_destroy(info.Img)
+		}
+	}
+
+	ic.Lock()
+	defer ic.Unlock()
+	for URL, node := range ic.Cache {
+		if node.Img != nil {
+			C.cairo_surface_destroy(node.Img)
+		}
+		delete(ic.Cache, URL)
+	}
 }
 
 func URLToFilename(URL string) string {
 	hash := sha1.Sum([]byte(URL))
 	base := base64.URLEncoding.EncodeToString(hash[:])
 	base = strings.Replace(base, "=", "_", -1)
-	return fmt.Sprintf("/home/juanval/gowitt/images/%s.png", base)
+	return filepath.Join(imageCacheDir(), base+".png")
 }
 
 func GetCachedImage(ic *ImageCache, URL string) *C.cairo_surface_t {
 
 	// Check if image already in cache
 	ic.Lock()
-	img, ok := ic.Cache[URL]
-	ic.Unlock()
-
-	if ok {
+	defer ic.Unlock()
+	if img, ok := ic.Cache[URL]; ok {
 		return img.Img
 	}
+	if ic.pending[URL] || time.Now().Before(ic.failed[URL].RetryAt) {
+		return nil
+	}
 
-	// If not in cache, request it
-	ic.URLRequests <- URL
+	// If not in cache, request it. When the downloaders are busy it's asked
+	// for again on the next redraw, waiting here would block the event loop
+	select {
+	case ic.URLRequests <- URL:
+		ic.pending[URL] = true
+	default:
+	}
 
 	return nil
 }
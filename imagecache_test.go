@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImageRequests(t *testing.T) {
+	ic := newImageCacheMaps()
+	const URL = "https://example.com/avatar.png"
+	requested := func() int {
+		n := 0
+		for len(ic.URLRequests) > 0 {
+			<-ic.URLRequests
+			n++
+		}
+		return n
+	}
+
+	GetCachedImage(ic, URL)
+	GetCachedImage(ic, URL)
+	if n := requested(); n != 1 {
+		t.Errorf("an image being downloaded was asked for %d times", n)
+	}
+
+	addCachedImage(ic, ImageInfo{URL: URL, Err: errors.New("404")})
+	GetCachedImage(ic, URL)
+	if n := requested(); n != 0 {
+		t.Errorf("a failed image was asked for again right away")
+	}
+	first := ic.failed[URL].RetryAt
+
+	// Once it's time, it's asked for again, and left alone longer if it fails again
+	ic.failed[URL] = imageFailure{1, time.Now().Add(-time.Second)}
+	GetCachedImage(ic, URL)
+	if n := requested(); n != 1 {
+		t.Errorf("a failed image was asked for %d times after its delay", n)
+	}
+	addCachedImage(ic, ImageInfo{URL: URL, Err: errors.New("404")})
+	if second := ic.failed[URL]; second.Count != 2 || !second.RetryAt.After(first.Add(ImageRetryDelay/2)) {
+		t.Errorf("the delay didn't grow after a second failure: %v, then %+v", first, second)
+	}
+
+	ic.failed[URL] = imageFailure{2, time.Now().Add(-time.Second)}
+	GetCachedImage(ic, URL)
+	requested()
+	addCachedImage(ic, ImageInfo{URL: URL, Filename: URLToFilename(URL)})
+	if _, ok := ic.Cache[URL]; !ok || len(ic.failed) != 0 || len(ic.pending) != 0 {
+		t.Errorf("a downloaded image left cached %v, failed %v, pending %v", ok, ic.failed, ic.pending)
+	}
+	GetCachedImage(ic, URL)
+	if n := requested(); n != 0 {
+		t.Errorf("a cached image was asked for %d times", n)
+	}
+}
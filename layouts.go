@@ -0,0 +1,226 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <stdlib.h>
+#include <pango/pango.h>
+#include <pango/pangocairo.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"sync"
+	"unicode/utf8"
+	"unsafe"
+)
+
+const LayoutErrorText = "[[INTERNAL ERROR, COULD NOT PROCESS TWEET]]"
+
+// Makes the pango layouts of tweets and messages. Pango isn't thread safe, and
+// tweets are laid out on worker goroutines, so the factory has a font map and
+// context of its own and everything using them or its layouts holds the lock
+type LayoutFactory struct {
+	sync.Mutex
+	FontMap  *C.PangoFontMap
+	Context  *C.PangoContext
+	FontDesc *C.PangoFontDescription
+	free     []*C.PangoLayout // of destroyed tweets, reused for new ones
+}
+
+// A pango font description like "Sans 10", with the emoji family added after
+// the font's families. Fontconfig's own fallback often picks a monochrome
+// emoji font, or one that can't be scaled
+func newFontDescription(font, emojiFont string) *C.PangoFontDescription {
+	cFont := C.CString(font)
+	Result := C.pango_font_description_from_string(cFont)
+	C.free(unsafe.Pointer(cFont))
+	if emojiFont != "" {
+		families := emojiFont
+		if family := C.pango_font_description_get_family(Result); family != nil {
+			families = C.GoString(family) + "," + emojiFont
+		}
+		cFamilies := C.CString(families)
+		C.pango_font_description_set_family(Result, cFamilies)
+		C.free(unsafe.Pointer(cFamilies))
+	}
+	return Result
+}
+
+func NewLayoutFactory(font, emojiFont string) *LayoutFactory {
+	f := &LayoutFactory{}
+	f.FontMap = C.pango_cairo_font_map_new()
+	f.Context = C.pango_font_map_create_context(f.FontMap)
+	f.FontDesc = newFontDescription(font, emojiFont)
+	return f
+}
+
+// Frees the factory and the layouts it kept for reuse. Layouts still in use
+// have to be recycled before
+func DestroyLayoutFactory(f *LayoutFactory) {
+	f.Lock()
+	defer f.Unlock()
+	for _, l := range f.free {
+		C.g_object_unref(C.gpointer(unsafe.Pointer(l)))
+	}
+	f.free = nil
+	C.g_object_unref(C.gpointer(unsafe.Pointer(f.Context)))
+	C.g_object_unref(C.gpointer(unsafe.Pointer(f.FontMap)))
+	C.pango_font_description_free(f.FontDesc)
+}
+
+// Changes the font of the layouts made from now on, when the theme changes
+func setLayoutFont(f *LayoutFactory, font, emojiFont string) {
+	f.Lock()
+	defer f.Unlock()
+	C.pango_font_description_free(f.FontDesc)
+	f.FontDesc = newFontDescription(font, emojiFont)
+}
+
+// Has to be called with the lock held
+func newLayout(f *LayoutFactory) *C.PangoLayout {
+	var Result *C.PangoLayout
+	if len(f.free) == 0 {
+		Result = C.pango_layout_new(f.Context)
+	} else {
+		Result = f.free[len(f.free)-1]
+		f.free = f.free[:len(f.free)-1]
+	}
+	// Reused layouts may be from before the font changed
+	C.pango_layout_set_font_description(Result, f.FontDesc)
+	return Result
+}
+
+// Sets plain text, has to be called with the lock held
+func setLayoutText(layout *C.PangoLayout, text string) {
+	cText := C.CString(text)
+	C.pango_layout_set_attributes(layout, nil)
+	C.pango_layout_set_text(layout, cText, -1)
+	C.free(unsafe.Pointer(cText))
+}
+
+// Lays out pango markup. Safe to call from any goroutine
+func markupLayout(f *LayoutFactory, markup string) *C.PangoLayout {
+	cMarkup := C.CString(markup)
+	defer C.free(unsafe.Pointer(cMarkup))
+
+	f.Lock()
+	defer f.Unlock()
+	layout := newLayout(f)
+	var attrs *C.PangoAttrList
+	var text *C.char
+	if C.pango_parse_markup(cMarkup, -1, 0, &attrs, &text, nil, nil) != 1 {
+		logError("error parsing", markup)
+		setLayoutText(layout, LayoutErrorText)
+		return layout
+	}
+	// The layout keeps its own reference to the attributes
+	C.pango_layout_set_attributes(layout, attrs)
+	C.pango_attr_list_unref(attrs)
+	C.pango_layout_set_text(layout, text, -1)
+	C.g_free(C.gpointer(unsafe.Pointer(text)))
+	return layout
+}
+
+// Lays out plain text, for messages. Safe to call from any goroutine
+func textLayout(f *LayoutFactory, text string) *C.PangoLayout {
+	f.Lock()
+	defer f.Unlock()
+	layout := newLayout(f)
+	setLayoutText(layout, text)
+	return layout
+}
+
+// Gives back a layout that isn't shown anymore
+func recycleLayout(f *LayoutFactory, l *C.PangoLayout) {
+	f.Lock()
+	f.free = append(f.free, l)
+	f.Unlock()
+}
+
+// Wraps a layout at width pixels, and returns its logical extents in pixels
+func wrapLayout(f *LayoutFactory, l *C.PangoLayout, width float64) (x, y, w, h float64) {
+	var Rect C.PangoRectangle
+	f.Lock()
+	C.pango_layout_set_width(l, PixelsToPango(width))
+	C.pango_layout_get_extents(l, nil, &Rect)
+	f.Unlock()
+	return PangoRectToPixels(&Rect)
+}
+
+// Draws a layout at the current point of the cairo context
+func showLayout(f *LayoutFactory, cairo *C.cairo_t, l *C.PangoLayout) {
+	f.Lock()
+	C.pango_cairo_show_layout(cairo, l)
+	f.Unlock()
+}
+
+// The text of a layout, without the markup
+func layoutText(f *LayoutFactory, l *C.PangoLayout) string {
+	f.Lock()
+	defer f.Unlock()
+	return C.GoString(C.pango_layout_get_text(l))
+}
+
+// Byte index in the layout's text of the character boundary closest to a
+// point, in pixels from the layout's origin. Points outside the layout go to
+// the closest line
+func layoutIndexAt(f *LayoutFactory, l *C.PangoLayout, x, y float64) int {
+	f.Lock()
+	defer f.Unlock()
+	var index, trailing C.int
+	C.pango_layout_xy_to_index(l, PixelsToPango(x), PixelsToPango(y), &index, &trailing)
+	// trailing counts characters past index, the caret goes after them
+	text := C.GoString(C.pango_layout_get_text(l))
+	Result := int(index)
+	for ; trailing > 0 && Result < len(text); trailing-- {
+		_, size := utf8.DecodeRuneInString(text[Result:])
+		Result += size
+	}
+	return Result
+}
+
+type Rect struct {
+	X, Y, W, H float64
+}
+
+// Where the text between two byte indexes is, in pixels from the layout's
+// origin. Text wrapped over several lines takes a rectangle per line at least
+func layoutRangeRects(f *LayoutFactory, l *C.PangoLayout, start, end int) []Rect {
+	f.Lock()
+	defer f.Unlock()
+	var Result []Rect
+	iter := C.pango_layout_get_iter(l)
+	defer C.pango_layout_iter_free(iter)
+	for {
+		line := C.pango_layout_iter_get_line_readonly(iter)
+		lineStart := int(line.start_index)
+		lineEnd := lineStart + int(line.length)
+		if start < lineEnd && end > lineStart {
+			from, to := start, end
+			if from < lineStart {
+				from = lineStart
+			}
+			if to > lineEnd {
+				to = lineEnd
+			}
+			var y0, y1 C.int
+			C.pango_layout_iter_get_line_yrange(iter, &y0, &y1)
+			var ranges *C.int
+			var n C.int
+			C.pango_layout_line_get_x_ranges(line, C.int(from), C.int(to), &ranges, &n)
+			pairs := (*[1 << 20]C.int)(unsafe.Pointer(ranges))[: 2*n : 2*n]
+			for i := 0; i < int(n); i++ {
+				Result = append(Result, Rect{
+					PangoToPixels(pairs[2*i]), PangoToPixels(y0),
+					PangoToPixels(pairs[2*i+1] - pairs[2*i]), PangoToPixels(y1 - y0),
+				})
+			}
+			C.g_free(C.gpointer(unsafe.Pointer(ranges)))
+		}
+		if C.pango_layout_iter_next_line(iter) == 0 {
+			break
+		}
+	}
+	return Result
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Workers lay out tweets and messages while the event loop wraps and draws
+// them, as when columns are reloaded in the background. Run with -race
+func TestLayoutFactoryConcurrency(t *testing.T) {
+	W := NewImageWindow(400, 300)
+	defer DestroyImageWindow(W)
+	f := W.Layouts
+	const Workers = 8
+	const Rounds = 200
+
+	shown := make(chan *TweetInfo, Workers)
+	var workers sync.WaitGroup
+	for i := 0; i < Workers; i++ {
+		workers.Add(1)
+		go func(i int) {
+			defer workers.Done()
+			for j := 0; j < Rounds; j++ {
+				tweet := &TweetInfo{Markup: fmt.Sprintf("<b>Worker %d</b> <small>@w%d</small>\n‏שלום %d 👋", i, i, j)}
+				W.LayoutPost(tweet)
+				shown <- tweet
+
+				message := &TweetInfo{Layout: textLayout(f, fmt.Sprintf("message %d from %d", j, i)), layouts: f}
+				if j%2 == 0 {
+					shown <- message
+				} else {
+					DestroyTweetInfo(message)
+				}
+				// Bad markup gets the error text instead
+				if j == 0 {
+					l := markupLayout(f, "<b>unclosed")
+					if text := layoutText(f, l); text != LayoutErrorText {
+						t.Errorf("Got %q for bad markup", text)
+					}
+					recycleLayout(f, l)
+				}
+			}
+		}(i)
+	}
+	go func() {
+		workers.Wait()
+		close(shown)
+	}()
+
+	// Fonts change with the theme while tweets are being laid out
+	th := currentTheme()
+	n := 0
+	for tweet := range shown {
+		if n%100 == 0 {
+			setLayoutFont(f, th.Font, th.EmojiFont)
+		}
+		_, _, w, h := wrapLayout(tweet.layouts, tweet.Layout, float64(100+n%200))
+		if w <= 0 || h <= 0 {
+			t.Errorf("Laid out %q as %vx%v", layoutText(tweet.layouts, tweet.Layout), w, h)
+		}
+		showLayout(tweet.layouts, W.Cairo, tweet.Layout)
+		DestroyTweetInfo(tweet)
+		n++
+	}
+	if want := Workers * Rounds * 3 / 2; n != want {
+		t.Errorf("Drew %d layouts, want %d", n, want)
+	}
+}
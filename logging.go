@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const LogFileName = "gowitt.log"
+const BannerDuration = 8 * time.Second
+const MaxBanners = 3
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func parseLogLevel(s string) LogLevel {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i)
+		}
+	}
+	return LevelInfo
+}
+
+var logger struct {
+	sync.Mutex
+	Level LogLevel
+	File  io.WriteCloser // nil until openLogFile is called
+	Quiet bool           // don't write to stderr, the terminal frontend owns the screen
+}
+
+// Everything logged is also appended to a file in the data directory, so
+// problems can be looked at after the window is gone
+func openLogFile(level LogLevel) error {
+	if err := os.MkdirAll(dataDir(), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dataDir(), LogFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	logger.Lock()
+	logger.Level = level
+	logger.File = file
+	logger.Unlock()
+	return nil
+}
+
+func closeLogFile() {
+	logger.Lock()
+	if logger.File != nil {
+		logger.File.Close()
+		logger.File = nil
+	}
+	logger.Unlock()
+}
+
+// Arguments are formatted like fmt.Println does
+func logAt(level LogLevel, args ...interface{}) {
+	logger.Lock()
+	defer logger.Unlock()
+	if level < logger.Level {
+		return
+	}
+	line := fmt.Sprintf("%s %-5s %s", time.Now().Format("2006-01-02 15:04:05"), levelNames[level], fmt.Sprintln(args...))
+	if !logger.Quiet {
+		os.Stderr.WriteString(line)
+	}
+	if logger.File != nil {
+		logger.File.Write([]byte(line))
+	}
+}
+
+func logDebug(args ...interface{}) { logAt(LevelDebug, args...) }
+func logInfo(args ...interface{})  { logAt(LevelInfo, args...) }
+func logWarn(args ...interface{})  { logAt(LevelWarn, args...) }
+func logError(args ...interface{}) { logAt(LevelError, args...) }
+
+// Short messages shown over the bottom of the window for a few seconds. Any
+// goroutine can add them, the window draws them
+type Banner struct {
+	Text    string
+	Expires time.Time
+}
+
+var banners struct {
+	sync.Mutex
+	Items   []Banner
+	Changed func() // asks for a redraw, nil when there's no window
+}
+
+// Logs an error and tells the user about it without interrupting anything
+func showError(args ...interface{}) {
+	logError(args...)
+	postBanner(strings.TrimSpace(fmt.Sprintln(args...)))
+}
+
+func postBanner(text string) {
+	banners.Lock()
+	banners.Items = append(banners.Items, Banner{text, time.Now().Add(BannerDuration)})
+	if len(banners.Items) > MaxBanners {
+		banners.Items = banners.Items[len(banners.Items)-MaxBanners:]
+	}
+	changed := banners.Changed
+	banners.Unlock()
+
+	if changed != nil {
+		changed()
+		// Once more to make it go away, unless the window is gone by then
+		time.AfterFunc(BannerDuration, func() {
+			banners.Lock()
+			changed := banners.Changed
+			banners.Unlock()
+			if changed != nil {
+				changed()
+			}
+		})
+	}
+}
+
+// The banners that haven't expired yet, oldest first
+func currentBanners(now time.Time) []Banner {
+	banners.Lock()
+	defer banners.Unlock()
+	var Result []Banner
+	for _, b := range banners.Items {
+		if b.Expires.After(now) {
+			Result = append(Result, b)
+		}
+	}
+	banners.Items = Result
+	return append([]Banner{}, Result...)
+}
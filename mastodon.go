@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Talks to the Mastodon REST API of an instance, with an access token created
+// from the instance's development settings
+type MastodonBackend struct {
+	Instance string // base URL, like https://mastodon.social
+	Token    string
+	Client   *http.Client
+}
+
+func NewMastodonBackend(instance, token string) *MastodonBackend {
+	return &MastodonBackend{
+		Instance: strings.TrimRight(instance, "/"),
+		Token:    token,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type mastodonAccount struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Acct        string `json:"acct"` // username@domain for remote accounts
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+}
+
+type mastodonStatus struct {
+	ID                 string          `json:"id"`
+	CreatedAt          string          `json:"created_at"`
+	InReplyToID        string          `json:"in_reply_to_id"`
+	InReplyToAccountID string          `json:"in_reply_to_account_id"`
+	Reblog             *mastodonStatus `json:"reblog"`
+	Content            string          `json:"content"` // HTML
+	Account            mastodonAccount `json:"account"`
+	Favourited         bool            `json:"favourited"`
+	Reblogged          bool            `json:"reblogged"`
+	FavouritesCount    int             `json:"favourites_count"`
+	ReblogsCount       int             `json:"reblogs_count"`
+	Tags               []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	Mentions []struct {
+		Acct string `json:"acct"`
+	} `json:"mentions"`
+}
+
+func (b *MastodonBackend) request(method, path string, params url.Values, result interface{}) error {
+	var body *strings.Reader
+	URL := b.Instance + path
+	if method == "GET" {
+		if len(params) > 0 {
+			URL += "?" + params.Encode()
+		}
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequest(method, URL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	op := "Mastodon " + method + " " + path
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return &FetchError{Op: op, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiError)
+		Result := &FetchError{Op: op, Err: fmt.Errorf("%s %s", resp.Status, apiError.Error)}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			// The reset time comes as an ISO 8601 date
+			Result.RetryAfter = 5 * time.Minute
+			if reset, err := time.Parse(time.RFC3339, resp.Header.Get("X-RateLimit-Reset")); err == nil && time.Until(reset) > 0 {
+				Result.RetryAfter = time.Until(reset)
+			}
+		}
+		return Result
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+var htmlLineBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>\s*<p>`)
+
+// Statuses come as HTML paragraphs, we want plain text
+func stripHTML(s string) string {
+	s = htmlLineBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+// Mastodon IDs are strings, but all current servers use numeric ones
+func parseMastodonID(s string) int64 {
+	ID, _ := strconv.ParseInt(s, 10, 64)
+	return ID
+}
+
+func postFromMastodon(s *mastodonStatus) Post {
+	createdAt, _ := time.Parse(time.RFC3339, s.CreatedAt)
+	p := Post{
+		ID:        parseMastodonID(s.ID),
+		Text:      stripHTML(s.Content),
+		CreatedAt: createdAt,
+		Author: User{
+			ID:         parseMastodonID(s.Account.ID),
+			Name:       s.Account.DisplayName,
+			ScreenName: s.Account.Acct,
+			AvatarURL:  s.Account.Avatar,
+		},
+		InReplyTo:      parseMastodonID(s.InReplyToID),
+		InReplyToUser:  parseMastodonID(s.InReplyToAccountID),
+		Favourited:     s.Favourited,
+		Reblogged:      s.Reblogged,
+		FavouriteCount: s.FavouritesCount,
+		ReblogCount:    s.ReblogsCount,
+	}
+	if p.Author.Name == "" {
+		p.Author.Name = s.Account.Username
+	}
+	for _, t := range s.Tags {
+		p.Entities = append(p.Entities, Entity{Kind: HashtagEntity, Text: t.Name})
+	}
+	for _, m := range s.Mentions {
+		p.Entities = append(p.Entities, Entity{Kind: MentionEntity, Text: m.Acct})
+	}
+	if s.Reblog != nil {
+		reblog := postFromMastodon(s.Reblog)
+		p.Reblog = &reblog
+	}
+	return p
+}
+
+func (b *MastodonBackend) Supports(source TimelineSource) bool {
+	return source.Kind == "home" || source.Kind == "public"
+}
+
+func (b *MastodonBackend) Timeline(source TimelineSource, sinceID, maxID int64, count int) ([]Post, error) {
+	var path string
+	switch source.Kind {
+	case "home":
+		path = "/api/v1/timelines/home"
+	case "public":
+		path = "/api/v1/timelines/public"
+	default:
+		return nil, errors.New("Mastodon doesn't support " + source.Kind + " timelines")
+	}
+
+	params := url.Values{"limit": {strconv.Itoa(count)}}
+	if sinceID != 0 {
+		params.Set("since_id", strconv.FormatInt(sinceID, 10))
+	}
+	if maxID != 0 {
+		// Mastodon's max_id is exclusive, twitter's isn't
+		params.Set("max_id", strconv.FormatInt(maxID+1, 10))
+	}
+	var statuses []mastodonStatus
+	if err := b.request("GET", path, params, &statuses); err != nil {
+		return nil, err
+	}
+	Result := make([]Post, len(statuses))
+	for i := range statuses {
+		Result[i] = postFromMastodon(&statuses[i])
+	}
+	return Result, nil
+}
+
+func (b *MastodonBackend) Post(ID int64) (Post, error) {
+	var status mastodonStatus
+	if err := b.request("GET", fmt.Sprintf("/api/v1/statuses/%d", ID), nil, &status); err != nil {
+		return Post{}, err
+	}
+	return postFromMastodon(&status), nil
+}
+
+func (b *MastodonBackend) Favourite(ID int64) error {
+	return b.request("POST", fmt.Sprintf("/api/v1/statuses/%d/favourite", ID), nil, nil)
+}
+
+func (b *MastodonBackend) Boost(ID int64) error {
+	return b.request("POST", fmt.Sprintf("/api/v1/statuses/%d/reblog", ID), nil, nil)
+}
+
+func (b *MastodonBackend) Reply(to *Post, text string) (Post, error) {
+	var status mastodonStatus
+	err := b.request("POST", "/api/v1/statuses", url.Values{
+		"status":         {"@" + to.Author.ScreenName + " " + text},
+		"in_reply_to_id": {strconv.FormatInt(to.ID, 10)},
+	}, &status)
+	if err != nil {
+		return Post{}, err
+	}
+	return postFromMastodon(&status), nil
+}
+
+// Remote authors are shown through the home instance, which knows the post by
+// its local ID
+func (b *MastodonBackend) PostURL(author string, ID int64) string {
+	return fmt.Sprintf("%s/@%s/%d", b.Instance, author, ID)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// A Mastodon instance standing in for a real one. It serves canned statuses
+// and keeps the requests it got
+type fakeInstance struct {
+	sync.Mutex
+	Requests []*http.Request
+	Forms    []url.Values
+}
+
+const fakeStatuses = `[
+	{"id": "103", "created_at": "2020-06-15T12:00:00.000Z", "content": "<p>Hello &amp; welcome</p><p>second</p>",
+	 "account": {"id": "7", "username": "ann", "acct": "ann", "display_name": "Ann", "avatar": "https://img/ann.png"},
+	 "favourited": true, "favourites_count": 3, "reblogs_count": 1,
+	 "tags": [{"name": "go"}], "mentions": [{"acct": "bob@example.org"}]},
+	{"id": "101", "created_at": "2020-06-15T11:00:00.000Z", "content": "",
+	 "account": {"id": "8", "username": "carl", "acct": "carl@example.org", "display_name": ""},
+	 "reblog": {"id": "50", "created_at": "2020-06-14T11:00:00.000Z", "content": "<p>boosted<br>text</p>",
+	            "account": {"id": "9", "username": "dee", "acct": "dee", "display_name": "Dee"}}}
+]`
+
+const fakeReply = `{"id": "104", "created_at": "2020-06-15T12:30:00.000Z", "in_reply_to_id": "103",
+	"in_reply_to_account_id": "7", "content": "<p>@ann hi</p>", "account": {"id": "1", "username": "me", "acct": "me"}}`
+
+func (f *fakeInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.Lock()
+	f.Requests = append(f.Requests, r)
+	f.Forms = append(f.Forms, r.Form)
+	f.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "The access token is invalid"}`)
+		return
+	}
+	switch {
+	case r.Method == "GET" && (r.URL.Path == "/api/v1/timelines/home" || r.URL.Path == "/api/v1/timelines/public"):
+		fmt.Fprint(w, fakeStatuses)
+	case r.Method == "POST" && (r.URL.Path == "/api/v1/statuses/103/favourite" || r.URL.Path == "/api/v1/statuses/103/reblog"):
+		fmt.Fprint(w, `{"id": "103"}`)
+	case r.Method == "POST" && r.URL.Path == "/api/v1/statuses":
+		fmt.Fprint(w, fakeReply)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": "Record not found"}`)
+	}
+}
+
+// The last request the instance got, and its parameters
+func lastRequest(f *fakeInstance) (*http.Request, url.Values) {
+	f.Lock()
+	defer f.Unlock()
+	return f.Requests[len(f.Requests)-1], f.Forms[len(f.Forms)-1]
+}
+
+func newFakeInstance(t *testing.T) (*MastodonBackend, *fakeInstance) {
+	f := &fakeInstance{}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return NewMastodonBackend(server.URL+"/", "secret"), f
+}
+
+func TestMastodonTimeline(t *testing.T) {
+	b, f := newFakeInstance(t)
+
+	for _, kind := range []string{"home", "public"} {
+		posts, err := b.Timeline(TimelineSource{Kind: kind}, 0, 0, 20)
+		if err != nil {
+			t.Fatal(kind, err)
+		}
+		req, params := lastRequest(f)
+		if req.URL.Path != "/api/v1/timelines/"+kind || params.Get("limit") != "20" {
+			t.Errorf("%s asked for %s", kind, req.URL)
+		}
+		if _, ok := params["since_id"]; ok {
+			t.Errorf("%s asked for since_id without one", kind)
+		}
+		if _, ok := params["max_id"]; ok {
+			t.Errorf("%s asked for max_id without one", kind)
+		}
+		if len(posts) != 2 {
+			t.Fatalf("%s got %d posts, want 2", kind, len(posts))
+		}
+	}
+
+	posts, err := b.Timeline(TimelineSource{Kind: "home"}, 0, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := posts[0]
+	if p.ID != 103 || p.Text != "Hello & welcome\nsecond" || p.Author.ID != 7 || p.Author.Name != "Ann" ||
+		p.Author.AvatarURL != "https://img/ann.png" || !p.Favourited || p.FavouriteCount != 3 || p.ReblogCount != 1 {
+		t.Errorf("Wrong first post %+v", p)
+	}
+	if p.CreatedAt.Hour() != 12 || p.CreatedAt.Year() != 2020 {
+		t.Errorf("Wrong date %v", p.CreatedAt)
+	}
+	if tags := postEntities(&p, HashtagEntity); len(tags) != 1 || tags[0] != "go" {
+		t.Errorf("Wrong hashtags %q", tags)
+	}
+	if mentions := postEntities(&p, MentionEntity); len(mentions) != 1 || mentions[0] != "bob@example.org" {
+		t.Errorf("Wrong mentions %q", mentions)
+	}
+	boost := posts[1]
+	if boost.Author.Name != "carl" || boost.Reblog == nil || boost.Reblog.ID != 50 || boost.Reblog.Text != "boosted\ntext" {
+		t.Errorf("Wrong boost %+v", boost)
+	}
+
+	// Mastodon's max_id is exclusive, the backend's isn't
+	if _, err := b.Timeline(TimelineSource{Kind: "home"}, 90, 110, 40); err != nil {
+		t.Fatal(err)
+	}
+	if _, params := lastRequest(f); params.Get("since_id") != "90" || params.Get("max_id") != "111" || params.Get("limit") != "40" {
+		t.Errorf("Range asked with %v", params)
+	}
+
+	if _, err := b.Timeline(TimelineSource{Kind: "mentions"}, 0, 0, 20); err == nil {
+		t.Error("Fetching mentions didn't fail")
+	}
+	if b.Supports(TimelineSource{Kind: "mentions"}) || !b.Supports(TimelineSource{Kind: "public"}) {
+		t.Error("Wrong supported timelines")
+	}
+}
+
+func TestMastodonActions(t *testing.T) {
+	b, f := newFakeInstance(t)
+
+	if err := b.Favourite(103); err != nil {
+		t.Error(err)
+	}
+	if req, _ := lastRequest(f); req.Method != "POST" || req.URL.Path != "/api/v1/statuses/103/favourite" {
+		t.Errorf("Favourite sent %s %s", req.Method, req.URL)
+	}
+	if err := b.Boost(103); err != nil {
+		t.Error(err)
+	}
+	if req, _ := lastRequest(f); req.Method != "POST" || req.URL.Path != "/api/v1/statuses/103/reblog" {
+		t.Errorf("Boost sent %s %s", req.Method, req.URL)
+	}
+	if err := b.Favourite(5); err == nil {
+		t.Error("Favouriting a missing status didn't fail")
+	}
+
+	to := Post{ID: 103, Author: User{ScreenName: "ann"}}
+	reply, err := b.Reply(&to, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, params := lastRequest(f)
+	if req.Method != "POST" || req.URL.Path != "/api/v1/statuses" ||
+		params.Get("status") != "@ann hi" || params.Get("in_reply_to_id") != "103" {
+		t.Errorf("Reply sent %s %s %v", req.Method, req.URL, params)
+	}
+	if reply.ID != 104 || reply.InReplyTo != 103 || reply.InReplyToUser != 7 {
+		t.Errorf("Wrong reply %+v", reply)
+	}
+
+	b.Token = "wrong"
+	err = b.Boost(103)
+	if fetchErr, ok := err.(*FetchError); !ok || fetchErr.Op != "Mastodon POST /api/v1/statuses/103/reblog" {
+		t.Errorf("Boosting with a wrong token returned %v", err)
+	}
+}
+
+func TestActionsOnBoosts(t *testing.T) {
+	b, f := newFakeInstance(t)
+	boost := &TweetInfo{ID: 900, ShownID: 103, ScreenName: "ann", RetweetedBy: "bob"}
+
+	if err := favouritePost(b, actionTarget(boost)); err != nil {
+		t.Error(err)
+	}
+	if req, _ := lastRequest(f); req.URL.Path != "/api/v1/statuses/103/favourite" {
+		t.Errorf("Favouriting a boost sent %s %s", req.Method, req.URL)
+	}
+	if err := boostPost(b, actionTarget(boost)); err != nil {
+		t.Error(err)
+	}
+	if req, _ := lastRequest(f); req.URL.Path != "/api/v1/statuses/103/reblog" {
+		t.Errorf("Boosting a boost sent %s %s", req.Method, req.URL)
+	}
+	if err := replyToPost(b, actionTarget(boost), "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if _, params := lastRequest(f); params.Get("status") != "@ann hi" || params.Get("in_reply_to_id") != "103" {
+		t.Errorf("Replying to a boost sent %v", params)
+	}
+}
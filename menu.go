@@ -0,0 +1,136 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <stdlib.h>
+#include <pango/pango.h>
+#include <pango/pangocairo.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"time"
+	"unsafe"
+)
+
+const MenuWidth = 180
+
+func menuItemHeight(W *XWindow) float64 {
+	return W.LineHeight + 2*currentTheme().SmallPadding
+}
+
+type MenuItem struct {
+	Label  string
+	Action func()
+}
+
+type ContextMenu struct {
+	X, Y  float64
+	Items []MenuItem
+}
+
+func OpenContextMenu(W *XWindow, x, y float64, items []MenuItem) {
+	if len(items) == 0 {
+		return
+	}
+	W.Menu = &ContextMenu{X: x, Y: y, Items: items}
+}
+
+// Checks a click against the open menu, running the clicked item's action.
+// Any click closes the menu. Returns true if the click was used up by the menu
+func HandleContextMenuClick(W *XWindow, click MouseClick) bool {
+	m := W.Menu
+	if m == nil || click.Button == 0 {
+		return false
+	}
+	W.Menu = nil
+
+	x, y := float64(click.X), float64(click.Y)
+	if x < m.X || x > m.X+MenuWidth || y < m.Y {
+		return true
+	}
+	item := int((y - m.Y) / menuItemHeight(W))
+	if item < len(m.Items) && click.Button == 1 {
+		m.Items[item].Action()
+	}
+	return true
+}
+
+func DrawContextMenu(W *XWindow) {
+	m := W.Menu
+	if m == nil {
+		return
+	}
+
+	th := currentTheme()
+	itemHeight := menuItemHeight(W)
+	setColor(W, th.Menu)
+	roundedRectangle(W, m.X, m.Y, MenuWidth, itemHeight*float64(len(m.Items)))
+	C.cairo_fill(W.Cairo)
+
+	setColor(W, th.Text)
+	for i, item := range m.Items {
+		DrawText(W, m.X+th.Padding, m.Y+float64(i)*itemHeight+th.SmallPadding, item.Label)
+	}
+}
+
+// Draws plain, unwrapped text with the current cairo source color
+func DrawText(W *XWindow, x, y float64, text string) {
+	cText := C.CString(text)
+	C.pango_layout_set_text(W.TextLayout, cText, -1)
+	C.free(unsafe.Pointer(cText))
+	C.cairo_move_to(W.Cairo, C.double(x), C.double(y))
+	C.pango_cairo_show_layout(W.Cairo, W.TextLayout)
+}
+
+// Measures a line of the window's font again, after it changed
+func updateLineHeight(W *XWindow) {
+	_, W.LineHeight = TextSize(W, "Ag")
+}
+
+// Returns the size in pixels DrawText would take for the given text
+func TextSize(W *XWindow, text string) (width, height float64) {
+	var w, h C.int
+	cText := C.CString(text)
+	C.pango_layout_set_text(W.TextLayout, cText, -1)
+	C.free(unsafe.Pointer(cText))
+	C.pango_layout_get_pixel_size(W.TextLayout, &w, &h)
+	return float64(w), float64(h)
+}
+
+// Draws W.Tooltip next to the mouse, kept inside the window
+func DrawTooltip(W *XWindow, windowWidth float64) {
+	if W.Tooltip == "" {
+		return
+	}
+	th := currentTheme()
+	w, h := TextSize(W, W.Tooltip)
+	x := float64(W.MouseX) + 12
+	y := float64(W.MouseY) + 16
+	if x+w+2*th.Padding > windowWidth {
+		x = windowWidth - w - 2*th.Padding
+	}
+
+	setColor(W, th.Panel)
+	roundedRectangle(W, x, y, w+2*th.Padding, h+2*th.SmallPadding)
+	C.cairo_fill(W.Cairo)
+	setColor(W, th.Text)
+	DrawText(W, x+th.Padding, y+th.SmallPadding, W.Tooltip)
+}
+
+// Draws the transient error banners along the bottom of the window, newest at the bottom
+func DrawBanners(W *XWindow, windowWidth, windowHeight float64) {
+	th := currentTheme()
+	items := currentBanners(time.Now())
+	y := windowHeight
+	for i := len(items) - 1; i >= 0; i-- {
+		_, h := TextSize(W, items[i].Text)
+		y -= h + 2*th.Padding
+		setColor(W, th.Error)
+		C.cairo_rectangle(W.Cairo, 0, C.double(y), C.double(windowWidth), C.double(h+2*th.Padding))
+		C.cairo_fill(W.Cairo)
+		setColor(W, th.Text)
+		DrawText(W, th.Padding, y+th.Padding, items[i].Text)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/godbus/dbus"
+	"html"
+	"strings"
+	"time"
+)
+
+const MaxSeparateNotifications = 3 // more new mentions or messages than this get a single summary notification
+
+type NotificationConfig struct {
+	Mentions       bool   `json:"mentions"`
+	DirectMessages bool   `json:"direct_messages"`
+	QuietStart     string `json:"quiet_start,omitempty"` // do not disturb from this local time, as "22:00"
+	QuietEnd       string `json:"quiet_end,omitempty"`   // until this one, as "08:00"
+}
+
+// Sends freedesktop notifications over the given bus connection. Anything
+// implementing org.freedesktop.Notifications on that bus will show them
+type Notifier struct {
+	Conn       *dbus.Conn
+	Config     NotificationConfig
+	ScreenName string // the logged in user, whose mentions we look for
+}
+
+func NewNotifier(conn *dbus.Conn, config NotificationConfig, screenName string) *Notifier {
+	return &Notifier{Conn: conn, Config: config, ScreenName: screenName}
+}
+
+func sendNotification(n *Notifier, summary, body string) error {
+	obj := n.Conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+		"gowitt",  // app name
+		uint32(0), // id of a notification to replace, none
+		"",        // icon
+		summary,
+		html.EscapeString(body), // bodies may contain markup
+		[]string{},              // actions
+		map[string]dbus.Variant{},
+		int32(-1)) // default timeout
+	return call.Err
+}
+
+func parseClockTime(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Quiet hours can wrap around midnight, e.g. 22:00 to 08:00
+func inQuietHours(config NotificationConfig, now time.Time) bool {
+	if config.QuietStart == "" || config.QuietEnd == "" {
+		return false
+	}
+	start, err := parseClockTime(config.QuietStart)
+	if err != nil {
+		logWarn("Invalid quiet_start", config.QuietStart)
+		return false
+	}
+	end, err := parseClockTime(config.QuietEnd)
+	if err != nil {
+		logWarn("Invalid quiet_end", config.QuietEnd)
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func isMention(p *Post, screenName string) bool {
+	if p.Reblog != nil || strings.EqualFold(p.Author.ScreenName, screenName) {
+		return false
+	}
+	for _, m := range postEntities(p, MentionEntity) {
+		if strings.EqualFold(m, screenName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Looks for mentions among newly fetched posts and notifies about them
+func NotifyNewPosts(n *Notifier, posts []Post, now time.Time) error {
+	if n == nil || !n.Config.Mentions || inQuietHours(n.Config, now) {
+		return nil
+	}
+
+	var mentions []*Post
+	for i := range posts {
+		if isMention(&posts[i], n.ScreenName) {
+			mentions = append(mentions, &posts[i])
+		}
+	}
+
+	if len(mentions) > MaxSeparateNotifications {
+		return sendNotification(n, fmt.Sprintf("%d new mentions", len(mentions)), "")
+	}
+	for _, p := range mentions {
+		summary := fmt.Sprintf("%s (@%s) mentioned you", p.Author.Name, p.Author.ScreenName)
+		if err := sendNotification(n, summary, p.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Notifies about newly received direct messages
+func NotifyDirectMessages(n *Notifier, messages []anaconda.DirectMessage, now time.Time) error {
+	if n == nil || !n.Config.DirectMessages || inQuietHours(n.Config, now) {
+		return nil
+	}
+
+	if len(messages) > MaxSeparateNotifications {
+		return sendNotification(n, fmt.Sprintf("%d new messages", len(messages)), "")
+	}
+	for _, dm := range messages {
+		summary := fmt.Sprintf("Message from %s (@%s)", dm.Sender.Name, dm.SenderScreenName)
+		if err := sendNotification(n, summary, dm.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
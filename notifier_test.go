@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/godbus/dbus"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for a notification daemon, keeping what it was asked to show
+type fakeNotifications struct {
+	sync.Mutex
+	Summaries []string
+}
+
+func (f *fakeNotifications) Notify(app string, replaces uint32, icon, summary, body string, actions []string,
+	hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+	f.Lock()
+	defer f.Unlock()
+	f.Summaries = append(f.Summaries, summary)
+	return uint32(len(f.Summaries)), nil
+}
+
+// Takes the summaries shown since the last call
+func takeSummaries(f *fakeNotifications) []string {
+	f.Lock()
+	defer f.Unlock()
+	Result := f.Summaries
+	f.Summaries = nil
+	return Result
+}
+
+func dialBus(t *testing.T, address string) *dbus.Conn {
+	conn, err := dbus.Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Starts a session bus of its own for the test, with a fake notification
+// daemon on it, and returns a connection to send notifications through
+func privateNotificationBus(t *testing.T) (*dbus.Conn, *fakeNotifications) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("No dbus-daemon:", err)
+	}
+	daemon := exec.Command(path, "--session", "--nofork", "--print-address")
+	stdout, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Skip("Could not start dbus-daemon:", err)
+	}
+	t.Cleanup(func() {
+		daemon.Process.Kill()
+		daemon.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal("Could not read the bus address:", err)
+	}
+
+	server := dialBus(t, strings.TrimSpace(address))
+	fake := &fakeNotifications{}
+	if err := server.Export(fake, "/org/freedesktop/Notifications", "org.freedesktop.Notifications"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := server.RequestName("org.freedesktop.Notifications", dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatal("Could not own org.freedesktop.Notifications:", reply, err)
+	}
+	return dialBus(t, strings.TrimSpace(address)), fake
+}
+
+func mentionOf(ID int64, screenName string) Post {
+	return Post{
+		ID:       ID,
+		Text:     "hi @" + screenName,
+		Author:   User{Name: "Someone", ScreenName: "someone"},
+		Entities: []Entity{{Kind: MentionEntity, Text: screenName}},
+	}
+}
+
+func messageFrom(name, screenName string) anaconda.DirectMessage {
+	var Result anaconda.DirectMessage
+	Result.Sender.Name = name
+	Result.SenderScreenName = screenName
+	Result.Text = "hello"
+	return Result
+}
+
+func TestNotifications(t *testing.T) {
+	conn, fake := privateNotificationBus(t)
+	noon := time.Date(2020, 6, 15, 12, 0, 0, 0, time.Local)
+	mentions := []Post{
+		mentionOf(1, "me"),
+		{ID: 2, Text: "not for me", Author: User{ScreenName: "someone"}},
+		mentionOf(3, "ME"),
+	}
+	messages := []anaconda.DirectMessage{messageFrom("Other", "other")}
+
+	n := NewNotifier(conn, NotificationConfig{Mentions: true, DirectMessages: true}, "me")
+	if err := NotifyNewPosts(n, mentions, noon); err != nil {
+		t.Fatal(err)
+	}
+	if got := takeSummaries(fake); len(got) != 2 || got[0] != "Someone (@someone) mentioned you" {
+		t.Errorf("Two mentions sent %q", got)
+	}
+	if err := NotifyDirectMessages(n, messages, noon); err != nil {
+		t.Fatal(err)
+	}
+	if got := takeSummaries(fake); len(got) != 1 || got[0] != "Message from Other (@other)" {
+		t.Errorf("A message sent %q", got)
+	}
+
+	// More mentions or messages than MaxSeparateNotifications are summed up
+	var many []Post
+	var manyMessages []anaconda.DirectMessage
+	for i := 0; i <= MaxSeparateNotifications; i++ {
+		many = append(many, mentionOf(int64(i+1), "me"))
+		manyMessages = append(manyMessages, messageFrom("Other", "other"))
+	}
+	if err := NotifyNewPosts(n, many, noon); err != nil {
+		t.Fatal(err)
+	}
+	if got := takeSummaries(fake); len(got) != 1 || got[0] != "4 new mentions" {
+		t.Errorf("%d mentions sent %q", len(many), got)
+	}
+	if err := NotifyDirectMessages(n, manyMessages, noon); err != nil {
+		t.Fatal(err)
+	}
+	if got := takeSummaries(fake); len(got) != 1 || got[0] != "4 new messages" {
+		t.Errorf("%d messages sent %q", len(manyMessages), got)
+	}
+
+	// Each type can be disabled on its own
+	n = NewNotifier(conn, NotificationConfig{Mentions: false, DirectMessages: true}, "me")
+	NotifyNewPosts(n, mentions, noon)
+	if got := takeSummaries(fake); len(got) != 0 {
+		t.Errorf("Disabled mentions sent %q", got)
+	}
+	n = NewNotifier(conn, NotificationConfig{Mentions: true, DirectMessages: false}, "me")
+	NotifyDirectMessages(n, messages, noon)
+	if got := takeSummaries(fake); len(got) != 0 {
+		t.Errorf("Disabled messages sent %q", got)
+	}
+
+	// Quiet hours wrapping around midnight
+	quiet := NotificationConfig{Mentions: true, DirectMessages: true, QuietStart: "22:00", QuietEnd: "08:00"}
+	n = NewNotifier(conn, quiet, "me")
+	for _, hour := range []int{22, 23, 0, 7} {
+		now := time.Date(2020, 6, 15, hour, 30, 0, 0, time.Local)
+		NotifyNewPosts(n, mentions, now)
+		NotifyDirectMessages(n, messages, now)
+		if got := takeSummaries(fake); len(got) != 0 {
+			t.Errorf("Sent %q at %d:30, in quiet hours", got, hour)
+		}
+	}
+	for _, hour := range []int{8, 12, 21} {
+		now := time.Date(2020, 6, 15, hour, 30, 0, 0, time.Local)
+		NotifyNewPosts(n, mentions, now)
+		NotifyDirectMessages(n, messages, now)
+		if got := takeSummaries(fake); len(got) != 3 {
+			t.Errorf("Sent %q at %d:30, out of quiet hours", got, hour)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/boltdb/bolt"
+	"github.com/godbus/dbus"
+	"time"
+)
+
+// Tells the event loop something was stored. Returns false if ctx was
+// cancelled, as the event loop may not be reading anymore
+func sendUpdate(ctx context.Context, updated chan<- TimelineUpdate, u TimelineUpdate) bool {
+	select {
+	case updated <- u:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Fetches new posts for every timeline until ctx is cancelled, sending the key
+// of each timeline that got stored to updated. api is only used for direct
+// messages, and is nil for accounts on other backends
+func pollTimelines(ctx context.Context, DB *bolt.DB, backend Backend, api *anaconda.TwitterApi, account string, sources []TimelineSource, interval time.Duration, notifier *Notifier, updated chan<- TimelineUpdate) {
+	for {
+		// Rate limits are per account, so don't go on with the other timelines
+		wait := interval
+		for _, s := range sources {
+			if ctx.Err() != nil {
+				return
+			}
+			newPosts, err := getTimelineData(DB, backend, s)
+			if retry := retryAfter(err); retry > 0 {
+				showError(err)
+				if retry > wait {
+					wait = retry
+				}
+				break
+			}
+			if err != nil {
+				showError(err)
+				continue
+			}
+			if err := NotifyNewPosts(notifier, newPosts, time.Now()); err != nil {
+				logWarn("Could not send notification:", err)
+			}
+			if !sendUpdate(ctx, updated, TimelineUpdate{account, timelineKey(s)}) {
+				return
+			}
+		}
+
+		if api != nil && wait == interval {
+			newMessages, err := getDirectMessages(DB, api)
+			if err != nil {
+				showError(twitterError("fetch direct messages", err))
+			} else {
+				if err := NotifyDirectMessages(notifier, newMessages, time.Now()); err != nil {
+					logWarn("Could not send notification:", err)
+				}
+				if !sendUpdate(ctx, updated, TimelineUpdate{account, DMTimelineKey}) {
+					return
+				}
+			}
+		}
+
+		if !sleepContext(ctx, wait) {
+			return
+		}
+	}
+}
+
+// Connects to the session bus to send notifications. Returns nil, which
+// disables notifications, if there's no bus
+func newNotifier(screenName string, config *Config) *Notifier {
+	if !config.Notifications.Mentions && !config.Notifications.DirectMessages {
+		return nil
+	}
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		logInfo("Notifications disabled, no session bus:", err)
+		return nil
+	}
+	return NewNotifier(conn, config.Notifications, screenName)
+}
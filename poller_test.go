@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A backend with a home timeline that gets a new post on every fetch
+type stubBackend struct {
+	sync.Mutex
+	Fetches int
+}
+
+func (b *stubBackend) Timeline(source TimelineSource, sinceID, maxID int64, count int) ([]Post, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.Fetches++
+	return []Post{{ID: int64(b.Fetches), Text: "post", Author: User{ID: 1, ScreenName: "stub"}}}, nil
+}
+
+func (b *stubBackend) Post(ID int64) (Post, error) {
+	return Post{}, errors.New("not found")
+}
+
+func (b *stubBackend) Favourite(ID int64) error { return nil }
+
+func (b *stubBackend) Boost(ID int64) error { return nil }
+
+func (b *stubBackend) Reply(to *Post, text string) (Post, error) { return Post{}, nil }
+
+func (b *stubBackend) PostURL(author string, ID int64) string { return "" }
+
+func (b *stubBackend) Supports(source TimelineSource) bool { return source.Kind == "home" }
+
+// Serves a png at /avatar.png, and never answers /slow.png until the client
+// gives up
+func avatarServer(t *testing.T) *httptest.Server {
+	var avatar bytes.Buffer
+	if err := png.Encode(&avatar, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.png" {
+			<-r.Context().Done()
+			return
+		}
+		w.Write(avatar.Bytes())
+	}))
+}
+
+// Waits a while for the goroutines of whatever was stopped to return
+func waitForGoroutines(baseline int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestShutdownLeavesNoGoroutines(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	DB, err := initDB(filepath.Join(t.TempDir(), "tweets.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DB.Close()
+	baseline := runtime.NumGoroutine()
+
+	server := avatarServer(t)
+	images := NewImageCache()
+	// One download stuck waiting for the server, and one nobody picks up
+	GetCachedImage(images, server.URL+"/slow.png")
+	GetCachedImage(images, server.URL+"/avatar.png")
+
+	// Nobody reads the updates either, so the poller blocks sending the first
+	backend := &stubBackend{}
+	ctx, cancel := context.WithCancel(context.Background())
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		pollTimelines(ctx, DB, backend, nil, "stub", []TimelineSource{{Kind: "home"}}, time.Hour, nil, make(chan TimelineUpdate))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(images.Downloads) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The poller didn't return after being cancelled")
+	}
+	CloseImageCache(images)
+	server.Close()
+	http.DefaultClient.CloseIdleConnections()
+
+	if n := waitForGoroutines(baseline); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines left running, there were %d before:\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+	if backend.Fetches != 1 {
+		t.Errorf("Fetched %d times, want once", backend.Fetches)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/ChimeraCoder/anaconda"
+	"time"
+)
+
+// A status from any backend: a tweet, a toot... This is what gets stored in
+// the database, so the JSON names are kept short
+type Post struct {
+	ID             int64     `json:"i"`
+	Text           string    `json:"t"`
+	CreatedAt      time.Time `json:"c"`
+	Author         User      `json:"a"`
+	InReplyTo      int64     `json:"r,omitempty"` // 0 if not a reply
+	InReplyToUser  int64     `json:"ru,omitempty"`
+	Reblog         *Post     `json:"rb,omitempty"` // the original post, when this is a retweet or boost
+	Favourited     bool      `json:"f,omitempty"`
+	Reblogged      bool      `json:"b,omitempty"`
+	FavouriteCount int       `json:"fc,omitempty"`
+	ReblogCount    int       `json:"bc,omitempty"`
+	Entities       []Entity  `json:"e,omitempty"`
+}
+
+type User struct {
+	ID         int64  `json:"i"`
+	Name       string `json:"n"`
+	ScreenName string `json:"s"`
+	AvatarURL  string `json:"p"`
+}
+
+const (
+	HashtagEntity = "hashtag"
+	MentionEntity = "mention"
+	URLEntity     = "url"
+)
+
+// Something tagged inside a post's text
+type Entity struct {
+	Kind string `json:"k"`           // one of the *Entity constants
+	Text string `json:"t"`           // hashtag or screen name without the # or @, or the URL as it appears
+	URL  string `json:"u,omitempty"` // where a URL really points to
+}
+
+// The post whose contents are shown: the original one for retweets
+func shownPost(p *Post) *Post {
+	if p.Reblog != nil {
+		return p.Reblog
+	}
+	return p
+}
+
+// Texts of all the entities of a kind
+func postEntities(p *Post, kind string) []string {
+	var Result []string
+	for _, e := range p.Entities {
+		if e.Kind == kind {
+			Result = append(Result, e.Text)
+		}
+	}
+	return Result
+}
+
+func postFromTweet(t *anaconda.Tweet) Post {
+	createdAt, _ := parseTwitterTime(t.CreatedAt)
+	p := Post{
+		ID:        t.Id,
+		Text:      t.Text,
+		CreatedAt: createdAt,
+		Author: User{
+			ID:         t.User.Id,
+			Name:       t.User.Name,
+			ScreenName: t.User.ScreenName,
+			AvatarURL:  t.User.ProfileImageURL,
+		},
+		InReplyTo:      t.InReplyToStatusID,
+		InReplyToUser:  t.InReplyToUserID,
+		Favourited:     t.Favorited,
+		Reblogged:      t.Retweeted,
+		FavouriteCount: t.FavoriteCount,
+		ReblogCount:    t.RetweetCount,
+	}
+	for _, h := range t.Entities.Hashtags {
+		p.Entities = append(p.Entities, Entity{Kind: HashtagEntity, Text: h.Text})
+	}
+	for _, m := range t.Entities.User_mentions {
+		p.Entities = append(p.Entities, Entity{Kind: MentionEntity, Text: m.Screen_name})
+	}
+	for _, u := range t.Entities.Urls {
+		p.Entities = append(p.Entities, Entity{Kind: URLEntity, Text: u.Url, URL: u.Expanded_url})
+	}
+	if t.RetweetedStatus != nil {
+		reblog := postFromTweet(t.RetweetedStatus)
+		p.Reblog = &reblog
+	}
+	return p
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Stored posts are encoded as a format byte followed by varints and length
+// prefixed strings. The fields needed to index and prune posts come first,
+// so decodePostHeader can read them without touching the text
+//
+//	flags, ID, created at, in reply to, in reply to user, author ID,
+//	author name, screen name, avatar, favourite count, reblog count,
+//	text, entities, and the reblogged post if the flags say there is one
+const PostFormat = 1
+
+const (
+	postFavourited = 1 << iota
+	postReblogged
+	postHasReblog
+)
+
+var errShortPost = errors.New("Stored post is truncated")
+
+// The fields of a stored post that can be read without decoding all of it
+type PostHeader struct {
+	ID            int64
+	CreatedAt     time.Time
+	InReplyTo     int64
+	InReplyToUser int64
+	Author        int64
+	ScreenName    string // of the author
+	Favourited    bool
+	Reblogged     bool
+	HasReblog     bool // it's a retweet or boost, and the other fields are the retweeter's
+}
+
+var entityKinds = []string{"", HashtagEntity, MentionEntity, URLEntity}
+
+func entityKindByte(kind string) byte {
+	for i, k := range entityKinds {
+		if k == kind {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+func encodePost(p *Post) ([]byte, error) {
+	return appendPost([]byte{PostFormat}, p), nil
+}
+
+func appendPost(buf []byte, p *Post) []byte {
+	var flags uint64
+	if p.Favourited {
+		flags |= postFavourited
+	}
+	if p.Reblogged {
+		flags |= postReblogged
+	}
+	if p.Reblog != nil {
+		flags |= postHasReblog
+	}
+	var createdAt int64
+	if !p.CreatedAt.IsZero() {
+		createdAt = p.CreatedAt.Unix()
+	}
+
+	buf = binary.AppendUvarint(buf, flags)
+	buf = binary.AppendVarint(buf, p.ID)
+	buf = binary.AppendVarint(buf, createdAt)
+	buf = binary.AppendVarint(buf, p.InReplyTo)
+	buf = binary.AppendVarint(buf, p.InReplyToUser)
+	buf = binary.AppendVarint(buf, p.Author.ID)
+	buf = appendString(buf, p.Author.Name)
+	buf = appendString(buf, p.Author.ScreenName)
+	buf = appendString(buf, p.Author.AvatarURL)
+	buf = binary.AppendUvarint(buf, uint64(p.FavouriteCount))
+	buf = binary.AppendUvarint(buf, uint64(p.ReblogCount))
+	buf = appendString(buf, p.Text)
+	buf = binary.AppendUvarint(buf, uint64(len(p.Entities)))
+	for _, e := range p.Entities {
+		buf = append(buf, entityKindByte(e.Kind))
+		buf = appendString(buf, e.Text)
+		buf = appendString(buf, e.URL)
+	}
+	if p.Reblog != nil {
+		buf = appendPost(buf, p.Reblog)
+	}
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Reads fields off the front of an encoded post. The first error sticks and
+// makes every later read return zero values
+type postDecoder struct {
+	data []byte
+	err  error
+}
+
+func readUvarint(d *postDecoder) uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errShortPost
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func readVarint(d *postDecoder) int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errShortPost
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func readString(d *postDecoder) string {
+	length := readUvarint(d)
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.data)) < length {
+		d.err = errShortPost
+		return ""
+	}
+	s := string(d.data[:length])
+	d.data = d.data[length:]
+	return s
+}
+
+func skipString(d *postDecoder) {
+	length := readUvarint(d)
+	if d.err != nil {
+		return
+	}
+	if uint64(len(d.data)) < length {
+		d.err = errShortPost
+		return
+	}
+	d.data = d.data[length:]
+}
+
+func readByte(d *postDecoder) byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = errShortPost
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func newPostDecoder(data []byte) (*postDecoder, error) {
+	if len(data) == 0 || data[0] != PostFormat {
+		return nil, errors.New("Stored post is in an unknown format")
+	}
+	return &postDecoder{data: data[1:]}, nil
+}
+
+func readPostHeader(d *postDecoder) (PostHeader, uint64) {
+	flags := readUvarint(d)
+	h := PostHeader{
+		ID:         readVarint(d),
+		Favourited: flags&postFavourited != 0,
+		Reblogged:  flags&postReblogged != 0,
+		HasReblog:  flags&postHasReblog != 0,
+	}
+	if createdAt := readVarint(d); createdAt != 0 {
+		h.CreatedAt = time.Unix(createdAt, 0)
+	}
+	h.InReplyTo = readVarint(d)
+	h.InReplyToUser = readVarint(d)
+	h.Author = readVarint(d)
+	return h, flags
+}
+
+func readPost(d *postDecoder) Post {
+	h, flags := readPostHeader(d)
+	p := Post{
+		ID:            h.ID,
+		CreatedAt:     h.CreatedAt,
+		InReplyTo:     h.InReplyTo,
+		InReplyToUser: h.InReplyToUser,
+		Favourited:    h.Favourited,
+		Reblogged:     h.Reblogged,
+	}
+	p.Author = User{
+		ID:         h.Author,
+		Name:       readString(d),
+		ScreenName: readString(d),
+		AvatarURL:  readString(d),
+	}
+	p.FavouriteCount = int(readUvarint(d))
+	p.ReblogCount = int(readUvarint(d))
+	p.Text = readString(d)
+
+	entityCnt := readUvarint(d)
+	// Every entity takes at least 3 bytes, don't trust a corrupted count
+	if entityCnt > uint64(len(d.data)) {
+		d.err = errShortPost
+	}
+	for i := uint64(0); i < entityCnt && d.err == nil; i++ {
+		var e Entity
+		if kind := int(readByte(d)); kind < len(entityKinds) {
+			e.Kind = entityKinds[kind]
+		}
+		e.Text = readString(d)
+		e.URL = readString(d)
+		p.Entities = append(p.Entities, e)
+	}
+	if flags&postHasReblog != 0 && d.err == nil {
+		reblog := readPost(d)
+		p.Reblog = &reblog
+	}
+	return p
+}
+
+func decodePost(data []byte) (*Post, error) {
+	d, err := newPostDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	Result := readPost(d)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &Result, nil
+}
+
+// Reads only the header of a stored post and the author's screen name,
+// without the text and entities
+func decodePostHeader(data []byte) (PostHeader, error) {
+	d, err := newPostDecoder(data)
+	if err != nil {
+		return PostHeader{}, err
+	}
+	h, _ := readPostHeader(d)
+	skipString(d)
+	h.ScreenName = readString(d)
+	return h, d.err
+}
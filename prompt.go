@@ -0,0 +1,80 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#cgo LDFLAGS: -lX11
+#include <X11/Xlib.h>
+#include <X11/Xutil.h>
+#include <X11/keysym.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import "unicode/utf8"
+
+func promptHeight(W *XWindow) float64 {
+	return W.LineHeight + 2*currentTheme().Padding
+}
+
+// Single line text input shown at the bottom of the window
+type TextPrompt struct {
+	Label    string
+	Text     string
+	OnSubmit func(text string)
+}
+
+func OpenPrompt(W *XWindow, label string, onSubmit func(text string)) {
+	W.Prompt = &TextPrompt{Label: label, OnSubmit: onSubmit}
+}
+
+// Feeds a key press to the open prompt. Returns false if there is no prompt,
+// so the key should be handled as a shortcut instead
+func HandlePromptKey(W *XWindow, ke *C.XKeyEvent) bool {
+	p := W.Prompt
+	if p == nil {
+		return false
+	}
+
+	var buf [32]C.char
+	var keysym C.KeySym
+	n := C.XLookupString(ke, &buf[0], C.int(len(buf)), &keysym, nil)
+
+	switch keysym {
+	case C.XK_Return, C.XK_KP_Enter:
+		W.Prompt = nil
+		p.OnSubmit(p.Text)
+	case C.XK_Escape:
+		W.Prompt = nil
+	case C.XK_BackSpace:
+		if len(p.Text) > 0 {
+			_, size := utf8.DecodeLastRuneInString(p.Text)
+			p.Text = p.Text[:len(p.Text)-size]
+		}
+	default:
+		// XLookupString gives us Latin-1
+		for i := 0; i < int(n); i++ {
+			if c := byte(buf[i]); c >= 0x20 && c != 0x7f {
+				p.Text += string(rune(c))
+			}
+		}
+	}
+	return true
+}
+
+func DrawPrompt(W *XWindow, windowWidth, windowHeight float64) {
+	p := W.Prompt
+	if p == nil {
+		return
+	}
+	th := currentTheme()
+	y := windowHeight - promptHeight(W)
+	setColor(W, th.Panel)
+	C.cairo_rectangle(W.Cairo, 0, C.double(y), C.double(windowWidth), C.double(promptHeight(W)))
+	C.cairo_fill(W.Cairo)
+
+	setColor(W, th.SecondaryText)
+	DrawText(W, th.Padding, y+th.Padding, p.Label)
+	labelWidth, _ := TextSize(W, p.Label)
+	setColor(W, th.Text)
+	DrawText(W, 2*th.Padding+labelWidth, y+th.Padding, p.Text+"▏")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strconv"
+)
+
+// Which parts of each timeline were fetched without holes. A fetch gets every
+// post of the timeline between the oldest and the newest it returned, so each
+// fetch covers a range of IDs, and ranges that touch are merged. Where two
+// ranges don't touch the backend has posts that were never fetched, a gap the
+// user can fill. They live in the ranges bucket, in a bucket per timeline,
+// keyed by the newest ID of each range with the oldest as value
+
+const GapFetchCount = 40 // posts asked for at a time when filling a gap
+
+// IDs of the oldest and newest posts of a range, both included
+type FetchedRange struct {
+	Oldest, Newest int64
+}
+
+// Posts newer than Older and older than Newer were never fetched
+type Gap struct {
+	Newer int64 // oldest post of the range above the gap
+	Older int64 // newest post of the range below it
+}
+
+func rangeKey(ID int64) []byte {
+	return []byte(fmt.Sprintf("%016x", ID))
+}
+
+// The ranges of a timeline bucket, oldest first
+func readRanges(Bucket *bolt.Bucket) ([]FetchedRange, error) {
+	var Result []FetchedRange
+	err := Bucket.ForEach(func(k, v []byte) error {
+		newest, err := strconv.ParseInt(string(k), 16, 64)
+		if err != nil {
+			return err
+		}
+		oldest, err := strconv.ParseInt(string(v), 16, 64)
+		if err != nil {
+			return err
+		}
+		Result = append(Result, FetchedRange{oldest, newest})
+		return nil
+	})
+	return Result, err
+}
+
+// What a fetch of up to count posts newer than sinceID and not newer than
+// maxID saw of the timeline. IDs are far apart, so there's no telling from
+// them whether posts are missing. It reaches down to sinceID when the page
+// wasn't full, as the backend had no more, or when it reached sinceID itself.
+// Returns false if it saw nothing
+func fetchedRange(posts []Post, sinceID, maxID int64, count int) (FetchedRange, bool) {
+	var Result FetchedRange
+	for _, p := range posts {
+		if Result.Newest == 0 || p.ID > Result.Newest {
+			Result.Newest = p.ID
+		}
+		if Result.Oldest == 0 || p.ID < Result.Oldest {
+			Result.Oldest = p.ID
+		}
+	}
+	if maxID != 0 {
+		Result.Newest = maxID
+	}
+	if sinceID != 0 && (len(posts) < count || Result.Oldest <= sinceID) {
+		Result.Oldest = sinceID
+	}
+	return Result, Result.Oldest != 0 && Result.Newest != 0
+}
+
+// Records a fetched range, merging it with the ranges it touches
+func addFetchedRange(Tx *bolt.Tx, Timeline string, r FetchedRange) error {
+	Bucket, err := Tx.Bucket([]byte("ranges")).CreateBucketIfNotExists([]byte(Timeline))
+	if err != nil {
+		return err
+	}
+	ranges, err := readRanges(Bucket)
+	if err != nil {
+		return err
+	}
+	for _, old := range ranges {
+		// IDs are integers, so ranges one apart leave nothing between them
+		if old.Oldest > r.Newest+1 || r.Oldest > old.Newest+1 {
+			continue
+		}
+		if old.Oldest < r.Oldest {
+			r.Oldest = old.Oldest
+		}
+		if old.Newest > r.Newest {
+			r.Newest = old.Newest
+		}
+		if err := Bucket.Delete(rangeKey(old.Newest)); err != nil {
+			return err
+		}
+	}
+	return Bucket.Put(rangeKey(r.Newest), []byte(strconv.FormatInt(r.Oldest, 16)))
+}
+
+// Forgets the ranges of posts older than oldest, after they were pruned.
+// oldest is 0 when the timeline has no posts left
+func trimRanges(Tx *bolt.Tx, Timeline string, oldest int64) error {
+	Bucket := Tx.Bucket([]byte("ranges")).Bucket([]byte(Timeline))
+	if Bucket == nil {
+		return nil
+	}
+	ranges, err := readRanges(Bucket)
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		if oldest != 0 && r.Newest >= oldest {
+			break
+		}
+		if err := Bucket.Delete(rangeKey(r.Newest)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The holes between the fetched ranges of a timeline, newest first
+func getGaps(DB *bolt.DB, Timeline string) ([]Gap, error) {
+	var Result []Gap
+	err := DB.View(func(Tx *bolt.Tx) error {
+		Bucket := Tx.Bucket([]byte("ranges")).Bucket([]byte(Timeline))
+		if Bucket == nil {
+			return nil
+		}
+		ranges, err := readRanges(Bucket)
+		if err != nil {
+			return err
+		}
+		for i := len(ranges) - 1; i > 0; i-- {
+			Result = append(Result, Gap{Newer: ranges[i].Oldest, Older: ranges[i-1].Newest})
+		}
+		return nil
+	})
+	return Result, err
+}
+
+// Fetches the newest posts missing in a gap. A gap too big for one fetch gets
+// smaller, the rest stays as a gap
+func fillGap(DB *bolt.DB, backend Backend, source TimelineSource, g Gap) ([]Post, error) {
+	return fetchTimelineRange(DB, backend, source, g.Older, g.Newer-1, GapFetchCount)
+}
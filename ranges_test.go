@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func postsWithIDs(IDs ...int64) []Post {
+	var Result []Post
+	for _, ID := range IDs {
+		Result = append(Result, Post{ID: ID})
+	}
+	return Result
+}
+
+func TestFetchedRange(t *testing.T) {
+	tests := []struct {
+		posts          []Post
+		sinceID, maxID int64
+		count          int
+		want           FetchedRange
+		ok             bool
+	}{
+		{postsWithIDs(30, 20, 10), 0, 0, 3, FetchedRange{10, 30}, true},
+		{nil, 0, 0, 3, FetchedRange{}, false},
+		// Nothing between sinceID and maxID
+		{nil, 10, 50, 3, FetchedRange{10, 50}, true},
+		{nil, 10, 0, 3, FetchedRange{}, false},
+		// A short page is all there was
+		{postsWithIDs(30, 20), 10, 0, 3, FetchedRange{10, 30}, true},
+		{postsWithIDs(30, 20), 10, 40, 3, FetchedRange{10, 40}, true},
+		// A full page can have stopped anywhere, unless it got to sinceID
+		{postsWithIDs(40, 30, 20), 10, 0, 3, FetchedRange{20, 40}, true},
+		{postsWithIDs(40, 30, 20), 10, 50, 3, FetchedRange{20, 50}, true},
+		{postsWithIDs(40, 30, 10), 10, 0, 3, FetchedRange{10, 40}, true},
+	}
+	for _, test := range tests {
+		got, ok := fetchedRange(test.posts, test.sinceID, test.maxID, test.count)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("fetchedRange(%d posts, %d, %d, %d) = %v, %v, want %v, %v",
+				len(test.posts), test.sinceID, test.maxID, test.count, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// Tweet IDs are snowflakes, consecutive tweets of a timeline are millions apart
+func TestFetchedRangeSnowflakes(t *testing.T) {
+	const sinceID = 1050118621198921728
+	page := postsWithIDs(1050131745089675264, 1050125430417387520, 1050120918265356288)
+
+	// Filling a gap above sinceID with room to spare gets all of it
+	if got, ok := fetchedRange(page, sinceID, 1050140000000000000, GapFetchCount); !ok || got != (FetchedRange{sinceID, 1050140000000000000}) {
+		t.Errorf("a short page covers %v, %v", got, ok)
+	}
+	// A full page leaves the rest of the gap
+	if got, ok := fetchedRange(page, sinceID, 0, len(page)); !ok || got != (FetchedRange{1050120918265356288, 1050131745089675264}) {
+		t.Errorf("a full page covers %v, %v", got, ok)
+	}
+	// Unless it reached sinceID
+	full := append(page, Post{ID: sinceID})
+	if got, ok := fetchedRange(full, sinceID, 0, len(full)); !ok || got != (FetchedRange{sinceID, 1050131745089675264}) {
+		t.Errorf("a full page with sinceID covers %v, %v", got, ok)
+	}
+}
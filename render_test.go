@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "write the golden images of TestRenderGolden from what's drawn")
+
+const RenderWidth = 400
+const RenderHeight = 160
+
+// A window to draw fixtures in, with the mouse away from them so nothing is hovered
+func newRenderWindow(t *testing.T) *XWindow {
+	W := NewImageWindow(RenderWidth, RenderHeight)
+	t.Cleanup(func() { DestroyImageWindow(W) })
+	W.MouseX, W.MouseY = -1, -1
+	return W
+}
+
+// Draws a tweet with the text of a fixture as the window would
+func drawFixture(W *XWindow, text string) {
+	post := Post{ID: 1, Text: text, Author: User{Name: "Fixture", ScreenName: "fixture"}}
+	tweet := GenerateTweetInfo(W, &post)
+	defer DestroyTweetInfo(tweet)
+	th := currentTheme()
+	DrawTweet(W, tweet, th.Padding, th.Padding, RenderWidth-2*th.Padding, false, MouseClick{})
+}
+
+func decodePNG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// Whether two images are the same but for antialiasing, which may differ a
+// little between cairo versions
+func sameImage(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []int{int(r1) - int(r2), int(g1) - int(g2), int(b1) - int(b2)} {
+				if d > 0x1000 || d < -0x1000 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Tweets in right to left scripts, mixed scripts and emoji, drawn as in the
+// window with the dark theme and compared to testdata/render/*.png. The golden
+// images depend on the fonts installed, write them again with -update after
+// checking the differences by eye
+func TestRenderGolden(t *testing.T) {
+	saved := currentTheme()
+	setTheme(darkTheme())
+	defer setTheme(saved)
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "render", "*.txt"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatal("No fixtures in testdata/render", err)
+	}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".txt")
+		t.Run(name, func(t *testing.T) {
+			text, err := ioutil.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			W := newRenderWindow(t)
+			drawFixture(W, strings.TrimSpace(string(text)))
+			golden := strings.TrimSuffix(fixture, ".txt") + ".png"
+			if *updateGolden {
+				if err := WriteImageWindow(W, golden); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := decodePNG(golden)
+			if os.IsNotExist(err) {
+				t.Fatalf("No %s, write it with -update and check it by eye", golden)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Kept where it can be looked at after a failure
+			drawn := filepath.Join(os.TempDir(), "gowitt-render-"+name+".png")
+			if err := WriteImageWindow(W, drawn); err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodePNG(drawn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameImage(got, want) {
+				t.Errorf("Drawn differently from %s, see %s", golden, drawn)
+				return
+			}
+			os.Remove(drawn)
+		})
+	}
+}
+
+// Copying selected text mustn't take the direction marks the layouts start
+// paragraphs with
+func TestSelectionWithoutDirectionMarks(t *testing.T) {
+	for _, text := range []string{"שלום עולם", "hello\nمرحبا"} {
+		W := newRenderWindow(t)
+		W.Selection = &TextSelection{Left: currentTheme().Padding, Anchor: SelectionPoint{1, 0}, Focus: SelectionPoint{1, 1 << 20}}
+		drawFixture(W, text)
+
+		got := selectionText(W)
+		if strings.ContainsAny(got, "\u200e\u200f") {
+			t.Errorf("Selected %q, with direction marks", got)
+		}
+		if !strings.Contains(got, strings.Split(text, "\n")[0]) {
+			t.Errorf("Selected %q, not the text of %q", got, text)
+		}
+	}
+}
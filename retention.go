@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type RetentionConfig struct {
+	Days        int `json:"days"`         // posts older than this are deleted, 0 keeps them forever
+	PerTimeline int `json:"per_timeline"` // only the newest posts of each timeline are kept, 0 for no limit
+	PruneHours  int `json:"prune_hours"`  // how often expired posts are looked for
+}
+
+// Posts the user favourited or wrote are never deleted
+func keepPost(h PostHeader, ownID int64) bool {
+	return h.Favourited || (ownID != 0 && h.Author == ownID)
+}
+
+// Deletes the posts that expired under the retention settings, along with
+// their timeline and reply index entries. Returns how many posts were deleted
+func pruneDB(DB *bolt.DB, r RetentionConfig, ownID int64, now time.Time) (int, error) {
+	if r.Days <= 0 && r.PerTimeline <= 0 {
+		return 0, nil
+	}
+	var cutoff time.Time
+	if r.Days > 0 {
+		cutoff = now.AddDate(0, 0, -r.Days)
+	}
+	expired := func(h PostHeader) bool {
+		return r.Days > 0 && !h.CreatedAt.IsZero() && h.CreatedAt.Before(cutoff)
+	}
+
+	Result := 0
+	err := DB.Update(func(Tx *bolt.Tx) error {
+		Tweets := Tx.Bucket([]byte("tweets"))
+
+		// Drop the expired entries of every timeline, and remember which
+		// posts are still in some timeline. Buckets can't be modified while
+		// iterating them, so entries are deleted afterwards
+		Timelines := Tx.Bucket([]byte("timelines"))
+		var names [][]byte
+		Timelines.ForEach(func(name, v []byte) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		dropped := make(map[string]bool)
+		referenced := make(map[string]bool)
+		for _, name := range names {
+			Timeline := Timelines.Bucket(name)
+			if Timeline == nil {
+				continue
+			}
+			var entries [][]byte
+			Cursor := Timeline.Cursor()
+			position := 0
+			for k, v := Cursor.Last(); k != nil; k, v = Cursor.Prev() {
+				data := Tweets.Get(v)
+				if data == nil {
+					// The post is already gone, so is its entry
+					entries = append(entries, append([]byte{}, k...))
+					continue
+				}
+				h, err := decodePostHeader(data)
+				if err != nil {
+					return err
+				}
+				tooMany := r.PerTimeline > 0 && position >= r.PerTimeline
+				position++
+				if !keepPost(h, ownID) && (tooMany || expired(h)) {
+					dropped[string(v)] = true
+					entries = append(entries, append([]byte{}, k...))
+				} else {
+					referenced[string(v)] = true
+				}
+			}
+			for _, k := range entries {
+				if err := Timeline.Delete(k); err != nil {
+					return err
+				}
+			}
+
+			var oldest int64
+			if k, _ := Timeline.Cursor().First(); k != nil {
+				oldest, _ = strconv.ParseInt(string(k), 16, 64)
+			}
+			if err := trimRanges(Tx, string(name), oldest); err != nil {
+				return err
+			}
+		}
+
+		// Posts only stored for threads are in no timeline, those go by age alone
+		var deleted []PostHeader
+		err := Tweets.ForEach(func(k, v []byte) error {
+			if referenced[string(k)] {
+				return nil
+			}
+			h, err := decodePostHeader(v)
+			if err != nil {
+				return err
+			}
+			if !keepPost(h, ownID) && (dropped[string(k)] || expired(h)) {
+				deleted = append(deleted, h)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		Replies := Tx.Bucket([]byte("replies"))
+		for _, h := range deleted {
+			if err := Tweets.Delete(tweetKey(h.ID)); err != nil {
+				return err
+			}
+			if h.InReplyTo != 0 {
+				if err := Replies.Delete(replyKey(h.InReplyTo, h.ID)); err != nil {
+					return err
+				}
+			}
+		}
+		Result = len(deleted)
+		return nil
+	})
+	return Result, err
+}
+
+// Prunes the database now and then until ctx is cancelled
+func prunePeriodically(ctx context.Context, DB *bolt.DB, r RetentionConfig, a *Account) {
+	if r.PruneHours <= 0 {
+		return
+	}
+	// Mastodon IDs are numeric too, see parseMastodonID
+	ownID, _ := strconv.ParseInt(a.UserID, 10, 64)
+	for {
+		deleted, err := pruneDB(DB, r, ownID, time.Now())
+		if err != nil {
+			logError("Could not prune old posts:", err)
+		} else if deleted > 0 {
+			logInfo("Deleted", deleted, "old posts of @"+a.ScreenName)
+		}
+
+		if !sleepContext(ctx, time.Duration(r.PruneHours)*time.Hour) {
+			return
+		}
+	}
+}
+
+// Rewrites a database file to give back the space freed by deleted posts.
+// Bolt never shrinks files by itself. gowitt can't be running meanwhile
+func compactDB(path string) error {
+	src, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return fmt.Errorf("%s is in use, quit gowitt first", path)
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	err = src.View(func(SrcTx *bolt.Tx) error {
+		return dst.Update(func(DstTx *bolt.Tx) error {
+			return SrcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				Bucket, err := DstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, Bucket)
+			})
+		})
+	})
+	if err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	before, _ := os.Stat(path)
+	after, _ := os.Stat(tmpPath)
+	if before != nil && after != nil {
+		fmt.Printf("%s: %d KiB -> %d KiB\n", path, before.Size()/1024, after.Size()/1024)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func copyBucket(src, dst *bolt.Bucket) error {
+	// Keys are copied in order, so pages can be filled completely
+	dst.FillPercent = 1
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		Nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), Nested)
+	})
+}
+
+// gowitt compact: compacts the database of every account
+func compactCommand(args []string) error {
+	credentials, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	var Result error
+	for i := range credentials.Accounts {
+		path := accountDBPath(&credentials.Accounts[i])
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := compactDB(path); err != nil {
+			fmt.Println("Could not compact", path+":", err)
+			Result = errors.New("Some databases could not be compacted")
+		}
+	}
+	return Result
+}
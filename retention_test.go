@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/boltdb/bolt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func timelineIDs(t *testing.T, DB *bolt.DB, Timeline string) []int64 {
+	posts, err := getLastNPosts(DB, Timeline, 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var Result []int64
+	for _, p := range posts {
+		Result = append(Result, p.ID)
+	}
+	return Result
+}
+
+func homeRanges(t *testing.T, DB *bolt.DB) []FetchedRange {
+	var Result []FetchedRange
+	err := DB.View(func(Tx *bolt.Tx) error {
+		var err error
+		Result, err = readRanges(Tx.Bucket([]byte("ranges")).Bucket([]byte("home")))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Result
+}
+
+func TestPruneAndCompact(t *testing.T) {
+	const ownID = 42
+	now := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	old, recent := now.AddDate(0, 0, -60), now.AddDate(0, 0, -1)
+	post := func(ID int64, createdAt time.Time, author int64) *Post {
+		return &Post{ID: ID, CreatedAt: createdAt, Author: User{ID: author, ScreenName: "someone"}}
+	}
+
+	expired := post(10, old, 5)
+	expired.InReplyTo = 20
+	own := post(20, old, ownID)
+	favourited := post(30, old, 5)
+	favourited.Favourited = true
+	// Over the limit of the home timeline, but the newest of the user's
+	inOtherTimeline := post(40, recent, 5)
+	oldThreadPost := post(80, old, 5)
+	oldThreadPost.InReplyTo = 70
+	threadPost := post(90, recent, 5)
+	threadPost.InReplyTo = 70
+
+	path := filepath.Join(t.TempDir(), "tweets.db")
+	DB, err := initDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { DB.Close() }()
+	err = DB.Update(func(Tx *bolt.Tx) error {
+		home := []*Post{expired, own, favourited, inOtherTimeline, post(50, recent, 5), post(60, recent, 5), post(70, recent, 5)}
+		for _, p := range append(home, oldThreadPost, threadPost) {
+			if err := storePost(Tx, p); err != nil {
+				return err
+			}
+		}
+		for _, p := range home {
+			if err := addToTimeline(Tx, "home", p.ID); err != nil {
+				return err
+			}
+		}
+		if err := addToTimeline(Tx, "user", inOtherTimeline.ID); err != nil {
+			return err
+		}
+		if err := addFetchedRange(Tx, "home", FetchedRange{10, 10}); err != nil {
+			return err
+		}
+		return addFetchedRange(Tx, "home", FetchedRange{20, 70})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := pruneDB(DB, RetentionConfig{Days: 30, PerTimeline: 3}, ownID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("pruneDB deleted %d posts, want 2", deleted)
+	}
+	check := func() {
+		for _, ID := range []int64{10, 80} {
+			if p, err := getPost(DB, ID); err != nil || p != nil {
+				t.Errorf("post %d is still stored: %v", ID, err)
+			}
+		}
+		for _, ID := range []int64{20, 30, 40, 50, 60, 70, 90} {
+			if p, err := getPost(DB, ID); err != nil || p == nil {
+				t.Errorf("post %d was deleted: %v", ID, err)
+			}
+		}
+		if IDs := timelineIDs(t, DB, "home"); !reflect.DeepEqual(IDs, []int64{70, 60, 50, 30, 20}) {
+			t.Errorf("home timeline is %v after pruning", IDs)
+		}
+		if IDs := timelineIDs(t, DB, "user"); !reflect.DeepEqual(IDs, []int64{40}) {
+			t.Errorf("user timeline is %v after pruning", IDs)
+		}
+		if IDs, err := getReplyIDs(DB, 20); err != nil || len(IDs) != 0 {
+			t.Errorf("replies to 20 are %v, %v", IDs, err)
+		}
+		if IDs, err := getReplyIDs(DB, 70); err != nil || !reflect.DeepEqual(IDs, []int64{90}) {
+			t.Errorf("replies to 70 are %v, %v", IDs, err)
+		}
+		if ranges := homeRanges(t, DB); !reflect.DeepEqual(ranges, []FetchedRange{{20, 70}}) {
+			t.Errorf("home ranges are %v after pruning", ranges)
+		}
+	}
+	check()
+
+	if err := DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compactDB(path); err != nil {
+		t.Fatal(err)
+	}
+	if DB, err = initDB(path); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
@@ -0,0 +1,216 @@
+package main
+
+/*
+#cgo pkg-config: cairo
+#cgo LDFLAGS: -lX11
+#include <stdlib.h>
+#include <X11/Xlib.h>
+#include <X11/Xatom.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// A place in the text of a tweet, as a byte index into its layout's text
+type SelectionPoint struct {
+	Tweet int64
+	Index int
+}
+
+// Text selected by dragging the mouse over tweets. It can span several tweets
+// of a column, which are ordered by ID, newest on top
+type TextSelection struct {
+	Left     float64 // x of the tweet cards it's in, which tells the columns apart
+	Anchor   SelectionPoint
+	Focus    SelectionPoint // follows the mouse while Dragging
+	Dragging bool
+	pieces   []string // the selected text of each tweet, gathered while drawing
+}
+
+// Atoms for talking to other clients about selections
+type SelectionAtoms struct {
+	Clipboard C.Atom
+	Targets   C.Atom
+	UTF8      C.Atom
+}
+
+func internAtom(W *XWindow, name string) C.Atom {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return C.XInternAtom(W.Display, cName, 0)
+}
+
+func initSelectionAtoms(W *XWindow) {
+	W.Atoms = SelectionAtoms{
+		Clipboard: internAtom(W, "CLIPBOARD"),
+		Targets:   internAtom(W, "TARGETS"),
+		UTF8:      internAtom(W, "UTF8_STRING"),
+	}
+	W.Owned = make(map[C.Atom]string)
+}
+
+// Whether a comes before b reading from top to bottom
+func pointBefore(a, b SelectionPoint) bool {
+	return a.Tweet > b.Tweet || (a.Tweet == b.Tweet && a.Index < b.Index)
+}
+
+// The part of a tweet's text the selection covers, as byte indexes
+func selectedRange(s *TextSelection, t *TweetInfo, textLen int) (start, end int, ok bool) {
+	first, last := s.Anchor, s.Focus
+	if pointBefore(last, first) {
+		first, last = last, first
+	}
+	if t.ID > first.Tweet || t.ID < last.Tweet {
+		return 0, 0, false
+	}
+	start, end = 0, textLen
+	if t.ID == first.Tweet {
+		start = first.Index
+	}
+	if t.ID == last.Tweet && last.Index < textLen {
+		end = last.Index
+	}
+	// The text may have changed since, as counts are in it
+	if start > textLen {
+		start = textLen
+	}
+	return start, end, start < end
+}
+
+// Starts or extends the selection as the mouse drags over the text of a tweet
+// drawn with its layout at (tx, ty). Called by DrawTweet for the tweet card
+// under the mouse
+func trackSelection(W *XWindow, t *TweetInfo, left, tx, ty float64, click MouseClick) {
+	point := SelectionPoint{t.ID, layoutIndexAt(t.layouts, t.Layout, float64(W.MouseX)-tx, float64(W.MouseY)-ty)}
+	if click.Button == 1 {
+		W.Selection = &TextSelection{Left: left, Anchor: point, Focus: point, Dragging: true}
+	} else if s := W.Selection; s != nil && s.Dragging && s.Left == left {
+		s.Focus = point
+	}
+}
+
+// Highlights the selected part of a tweet whose layout is drawn at (tx, ty),
+// and keeps the text for when the drag ends
+func drawSelection(W *XWindow, t *TweetInfo, left, tx, ty float64) {
+	s := W.Selection
+	if s == nil || s.Left != left {
+		return
+	}
+	text := layoutText(t.layouts, t.Layout)
+	start, end, ok := selectedRange(s, t, len(text))
+	if !ok {
+		return
+	}
+	setColor(W, currentTheme().Selection)
+	for _, r := range layoutRangeRects(t.layouts, t.Layout, start, end) {
+		C.cairo_rectangle(W.Cairo, C.double(tx+r.X), C.double(ty+r.Y), C.double(r.W), C.double(r.H))
+	}
+	C.cairo_fill(W.Cairo)
+	s.pieces = append(s.pieces, withoutDirection(text[start:end]))
+}
+
+// The selected text, as of the last redraw
+func selectionText(W *XWindow) string {
+	if W.Selection == nil {
+		return ""
+	}
+	return strings.Join(W.Selection.pieces, "\n\n")
+}
+
+// Called after the redraw following the mouse button release. What was
+// selected becomes the PRIMARY selection, for middle click pasting
+func FinishSelection(W *XWindow) {
+	s := W.Selection
+	if s == nil || !s.Dragging {
+		return
+	}
+	s.Dragging = false
+	text := selectionText(W)
+	if text == "" {
+		W.Selection = nil
+		return
+	}
+	ownSelection(W, C.XA_PRIMARY, text)
+}
+
+// Makes the window the owner of an X selection, so other clients ask it for
+// the text when pasting
+func ownSelection(W *XWindow, selection C.Atom, text string) {
+	// ICCCM wants the time of the event that made us take it, not CurrentTime,
+	// so clients can tell which of two owners came last
+	C.XSetSelectionOwner(W.Display, selection, W.Window, W.EventTime)
+	if C.XGetSelectionOwner(W.Display, selection) != W.Window {
+		showError("Could not take the selection")
+		return
+	}
+	W.Owned[selection] = text
+}
+
+// Puts text in the clipboard
+func CopyText(W *XWindow, text string) {
+	if text != "" {
+		ownSelection(W, W.Atoms.Clipboard, text)
+	}
+}
+
+// Another client took the selection, so ours isn't shown anymore
+func SelectionCleared(W *XWindow, selection C.Atom) {
+	delete(W.Owned, selection)
+	if selection == C.XA_PRIMARY {
+		W.Selection = nil
+	}
+}
+
+// STRING is Latin-1. Whatever it doesn't have becomes a question mark
+func latin1(text string) string {
+	Result := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xFF {
+			r = '?'
+		}
+		Result = append(Result, byte(r))
+	}
+	return string(Result)
+}
+
+// Answers a client asking for the text of a selection the window owns
+func AnswerSelectionRequest(W *XWindow, req *C.XSelectionRequestEvent) {
+	var ev C.XEvent
+	notify := (*C.XSelectionEvent)(unsafe.Pointer(&ev))
+	notify._type = C.SelectionNotify
+	notify.display = req.display
+	notify.requestor = req.requestor
+	notify.selection = req.selection
+	notify.target = req.target
+	notify.time = req.time
+	notify.property = C.None
+
+	// Clients from before ICCCM don't say where they want it
+	property := req.property
+	if property == C.None {
+		property = req.target
+	}
+	if text, ok := W.Owned[req.selection]; ok {
+		switch req.target {
+		case W.Atoms.Targets:
+			targets := []C.Atom{W.Atoms.Targets, W.Atoms.UTF8, C.XA_STRING}
+			C.XChangeProperty(W.Display, req.requestor, property, C.XA_ATOM, 32, C.PropModeReplace,
+				(*C.uchar)(unsafe.Pointer(&targets[0])), C.int(len(targets)))
+			notify.property = property
+		case W.Atoms.UTF8, C.XA_STRING:
+			if req.target == C.XA_STRING {
+				text = latin1(text)
+			}
+			cText := C.CString(text)
+			C.XChangeProperty(W.Display, req.requestor, property, req.target, 8, C.PropModeReplace,
+				(*C.uchar)(unsafe.Pointer(cText)), C.int(len(text)))
+			C.free(unsafe.Pointer(cText))
+			notify.property = property
+		}
+	}
+	C.XSendEvent(W.Display, req.requestor, 0, 0, &ev)
+}
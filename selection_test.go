@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLatin1(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain text\n", "plain text\n"},
+		{"café à 10°", "caf\xe9 \xe0 10\xb0"},
+		{"日本 👋 ok", "?? ? ok"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := latin1(test.in); got != test.want {
+			t.Errorf("latin1(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
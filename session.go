@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ChimeraCoder/anaconda"
+	"github.com/boltdb/bolt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const LegacyDBPath = "tweets.db" // where everything was stored before there were multiple accounts
+const ShutdownTimeout = 5 * time.Second
+
+// Everything belonging to one logged in account. All accounts poll at the
+// same time, and the window shows the active one
+type Session struct {
+	Account Account // a copy, logging in more accounts moves the credentials around
+	DB      *bolt.DB
+	Backend Backend
+	API     *anaconda.TwitterApi // nil for accounts not on twitter
+	Filters *Filters
+	Columns []*Column
+	// Background work of the session: the poller, the pruner and what the
+	// window runs for it, see RunInBackground
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// Sent by the pollers when they stored something new
+type TimelineUpdate struct {
+	Account  string // accountKey of the session
+	Timeline string // timeline key, or DMTimelineKey
+}
+
+func dataDir() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	return filepath.Join(dir, "gowitt")
+}
+
+// Named after the user ID, which stays the same when the screen name changes.
+// Mastodon IDs are only unique in their instance
+func accountDBPath(a *Account) string {
+	if a.UserID == "" {
+		return screenNameDBPath(a)
+	}
+	name := a.UserID
+	if a.Backend == "mastodon" {
+		if u, err := url.Parse(a.Instance); err == nil && u.Host != "" {
+			name = u.Host + "-" + a.UserID
+		}
+	}
+	return filepath.Join(dataDir(), "tweets-"+name+".db")
+}
+
+// Where databases were before they were named after user IDs
+func screenNameDBPath(a *Account) string {
+	return filepath.Join(dataDir(), "tweets-"+a.ScreenName+".db")
+}
+
+// Moves the database of an account from where older versions kept it: named
+// after its screen name, or for the first account, the one from before
+// accounts existed
+func migrateLegacyDB(c *Credentials, a *Account) error {
+	if _, err := os.Stat(accountDBPath(a)); !os.IsNotExist(err) {
+		return nil
+	}
+	old := screenNameDBPath(a)
+	if _, err := os.Stat(old); err != nil {
+		if len(c.Accounts) == 0 || c.Accounts[0].ScreenName != a.ScreenName {
+			return nil
+		}
+		if _, err := os.Stat(LegacyDBPath); err != nil {
+			return nil
+		}
+		old = LegacyDBPath
+	}
+	logInfo("Moving", old, "to", accountDBPath(a))
+	return os.Rename(old, accountDBPath(a))
+}
+
+func OpenSession(ctx context.Context, W *XWindow, c *Credentials, a *Account, config *Config, updates chan<- TimelineUpdate) (*Session, error) {
+	DB, err := openAccountDB(c, a)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		Account: *a,
+		DB:      DB,
+		Backend: newBackend(a),
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.ctx = ctx
+	if twitter, ok := s.Backend.(*TwitterBackend); ok {
+		s.API = twitter.API
+	}
+	if s.Filters, err = loadFilters(DB); err != nil {
+		DB.Close()
+		return nil, err
+	}
+	sources := backendColumns(s.Backend, config.Columns)
+	for _, source := range sources {
+		column, err := NewColumn(W, DB, s.Filters, source)
+		if err != nil {
+			CloseSession(s)
+			return nil, err
+		}
+		s.Columns = append(s.Columns, column)
+	}
+
+	if config.PollMinutes > 0 {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			pollTimelines(ctx, DB, s.Backend, s.API, accountKey(&s.Account), sources, time.Duration(config.PollMinutes)*time.Minute,
+				newNotifier(s.Account.ScreenName, config), updates)
+		}()
+	}
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		prunePeriodically(ctx, DB, config.Retention, &s.Account)
+	}()
+	return s, nil
+}
+
+// Stops the session's background work and closes its database. A fetch can't
+// be interrupted halfway, if one takes longer than ShutdownTimeout the
+// database is left open for the process exit to take care of
+func CloseSession(s *Session) {
+	s.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(stopped)
+	}()
+
+	for _, c := range s.Columns {
+		ClearTweetsBuffer(c.Tweets)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(ShutdownTimeout):
+		logWarn("@"+s.Account.ScreenName, "is still fetching, not closing its database")
+		return
+	}
+	if err := s.DB.Close(); err != nil {
+		logError("Could not close database:", err)
+	}
+}
+
+// Stores what the window changed for the active session and didn't store yet
+func flushSession(W *XWindow) {
+	if W.Session == nil {
+		return
+	}
+	if err := SaveReadMarkers(W, W.Session.DB); err != nil {
+		logError("Could not save read markers:", err)
+	}
+	if W.FiltersDirty {
+		W.FiltersDirty = false
+		if err := saveFilters(W.Session.DB, W.Filters); err != nil {
+			logError("Could not save filters:", err)
+		}
+	}
+}
+
+// Shows a session's timelines in the window
+func ActivateSession(W *XWindow, s *Session) {
+	CloseThread(W)
+	CloseDMView(W)
+	W.Menu = nil
+	W.FiltersView = false
+
+	W.Session = s
+	W.Columns = s.Columns
+	W.Filters = s.Filters
+	W.ScreenName = s.Account.ScreenName
+	if W.ActiveColumn >= len(W.Columns) {
+		W.ActiveColumn = 0
+	}
+	UpdateWindowTitle(W)
+}
+
+// Opens the database of the current account, for commands that run without a window
+func openCurrentDB() (*bolt.DB, *Account, error) {
+	credentials, err := loadCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+	a := currentAccount(credentials)
+	if a == nil {
+		return nil, nil, errors.New("Not logged in, run gowitt once to log in")
+	}
+	DB, err := openAccountDB(credentials, a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return DB, a, nil
+}
+
+func openAccountDB(c *Credentials, a *Account) (*bolt.DB, error) {
+	if err := os.MkdirAll(dataDir(), 0700); err != nil {
+		return nil, err
+	}
+	if err := migrateLegacyDB(c, a); err != nil {
+		return nil, err
+	}
+	return initDB(accountDBPath(a))
+}
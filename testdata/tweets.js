@@ -0,0 +1,48 @@
+window.YTD.tweets.part0 = [ {
+  "tweet" : {
+    "id_str" : "1050118621198921728",
+    "full_text" : "Trying out the new #gowitt build https://t.co/abc",
+    "created_at" : "Wed Oct 10 20:19:24 +0000 2018",
+    "favorite_count" : "3",
+    "retweet_count" : "1",
+    "favorited" : false,
+    "retweeted" : false,
+    "entities" : {
+      "hashtags" : [ { "text" : "gowitt" } ],
+      "user_mentions" : [ ],
+      "urls" : [ { "url" : "https://t.co/abc", "expanded_url" : "https://example.com/release" } ]
+    }
+  }
+}, {
+  "tweet" : {
+    "id_str" : "1050200000000000001",
+    "full_text" : "@ann thanks, fixed",
+    "created_at" : "Thu Oct 11 01:44:10 +0000 2018",
+    "in_reply_to_status_id_str" : "1050199999999999999",
+    "in_reply_to_user_id_str" : "783214",
+    "favorite_count" : "0",
+    "retweet_count" : "0",
+    "favorited" : true,
+    "retweeted" : false,
+    "entities" : {
+      "hashtags" : [ ],
+      "user_mentions" : [ { "screen_name" : "ann" } ],
+      "urls" : [ ]
+    }
+  }
+}, {
+  "tweet" : {
+    "id_str" : "1050300000000000002",
+    "full_text" : "Already fetched, the archive has less of it",
+    "created_at" : "Thu Oct 11 08:00:00 +0000 2018",
+    "favorite_count" : "0",
+    "retweet_count" : "0",
+    "favorited" : false,
+    "retweeted" : false,
+    "entities" : {
+      "hashtags" : [ ],
+      "user_mentions" : [ ],
+      "urls" : [ ]
+    }
+  }
+} ]
@@ -0,0 +1,320 @@
+package main
+
+/*
+#cgo pkg-config: pangocairo
+#include <pango/pango.h>
+#include <pango/pangocairo.h>
+#include <cairo/cairo.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ThemesDirName = "themes"
+const ThemeCheckInterval = 2 * time.Second
+
+// A color as "#RRGGBB" or "#RGB", like in pango markup
+type Color string
+
+// Colors, fonts and spacing of everything drawn. Theme files are JSON with
+// the same names, and only need what they change from their base theme
+type Theme struct {
+	Base string `json:"base"` // built-in theme the file starts from, "dark" if empty
+
+	Background      Color `json:"background"`
+	Card            Color `json:"card"`
+	CardHighlight   Color `json:"card_highlight"` // the focused tweet of a conversation
+	Text            Color `json:"text"`
+	SecondaryText   Color `json:"secondary_text"` // ages, hints and tweet actions
+	Link            Color `json:"link"`
+	Favourite       Color `json:"favourite"`
+	Boost           Color `json:"boost"`
+	Accent          Color `json:"accent"` // new tweets divider and gap rows
+	Header          Color `json:"header"` // column titles, or tabs
+	HeaderActive    Color `json:"header_active"`
+	Hover           Color `json:"hover"`
+	Selection       Color `json:"selection"`
+	Menu            Color `json:"menu"`
+	Panel           Color `json:"panel"` // tooltips and the prompt
+	Error           Color `json:"error"`
+	SentMessage     Color `json:"sent_message"`
+	ReceivedMessage Color `json:"received_message"`
+
+	Font      string `json:"font"`       // pango font description, like "Sans 10"
+	EmojiFont string `json:"emoji_font"` // family used for the emoji the font lacks
+
+	// In pixels
+	Padding      float64 `json:"padding"`
+	SmallPadding float64 `json:"small_padding"`
+	AvatarSize   float64 `json:"avatar_size"`
+	CornerRadius float64 `json:"corner_radius"`
+}
+
+func darkTheme() *Theme {
+	return &Theme{
+		Background:      "#1A1A1A",
+		Card:            "#333333",
+		CardHighlight:   "#40404D",
+		Text:            "#F2F2F2",
+		SecondaryText:   "#777777",
+		Link:            "#8888FF",
+		Favourite:       "#DD2222",
+		Boost:           "#33DD33",
+		Accent:          "#598CD9",
+		Header:          "#262626",
+		HeaderActive:    "#404040",
+		Hover:           "#383845",
+		Selection:       "#405999",
+		Menu:            "#4D4D4D",
+		Panel:           "#0D0D0D",
+		Error:           "#801F1F",
+		SentMessage:     "#334D73",
+		ReceivedMessage: "#404040",
+		Font:            "Sans 10",
+		EmojiFont:       "Noto Color Emoji",
+		Padding:         5,
+		SmallPadding:    2,
+		AvatarSize:      48,
+	}
+}
+
+func lightTheme() *Theme {
+	return &Theme{
+		Background:      "#EBEBEB",
+		Card:            "#FFFFFF",
+		CardHighlight:   "#E3EAF7",
+		Text:            "#1A1A1A",
+		SecondaryText:   "#808080",
+		Link:            "#2255CC",
+		Favourite:       "#D02020",
+		Boost:           "#1E9E1E",
+		Accent:          "#3A6FC4",
+		Header:          "#D9D9D9",
+		HeaderActive:    "#FFFFFF",
+		Hover:           "#E6E9F2",
+		Selection:       "#B4CCF0",
+		Menu:            "#F7F7F7",
+		Panel:           "#FFFFE8",
+		Error:           "#F2B8B8",
+		SentMessage:     "#CFE0F7",
+		ReceivedMessage: "#FFFFFF",
+		Font:            "Sans 10",
+		EmojiFont:       "Noto Color Emoji",
+		Padding:         5,
+		SmallPadding:    2,
+		AvatarSize:      48,
+		CornerRadius:    6,
+	}
+}
+
+var builtinThemes = map[string]func() *Theme{
+	"dark":  darkTheme,
+	"light": lightTheme,
+}
+
+// The theme everything is drawn with. Workers read it to build markup, so
+// it's only swapped under the lock, and never modified
+var themes struct {
+	sync.Mutex
+	Current *Theme
+}
+
+func currentTheme() *Theme {
+	themes.Lock()
+	defer themes.Unlock()
+	if themes.Current == nil {
+		themes.Current = darkTheme()
+	}
+	return themes.Current
+}
+
+func setTheme(t *Theme) {
+	themes.Lock()
+	themes.Current = t
+	themes.Unlock()
+}
+
+// Switches the window to another theme. Tweets and messages keep the colors and
+// font they were laid out with until they're laid out again
+func ApplyTheme(W *XWindow, t *Theme) {
+	setTheme(t)
+	setLayoutFont(W.Layouts, t.Font, t.EmojiFont)
+	C.pango_font_description_free(W.FontDesc)
+	W.FontDesc = newFontDescription(t.Font, t.EmojiFont)
+	C.pango_layout_set_font_description(W.TextLayout, W.FontDesc)
+	updateLineHeight(W)
+}
+
+// As 0xRRGGBB
+func (c Color) value() (uint32, error) {
+	s := string(c)
+	if len(s) == 4 && s[0] == '#' {
+		s = string([]byte{'#', s[1], s[1], s[2], s[2], s[3], s[3]})
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return 0, fmt.Errorf("Invalid color %q, it should look like #RRGGBB", string(c))
+	}
+	rgb, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid color %q, it should look like #RRGGBB", string(c))
+	}
+	return uint32(rgb), nil
+}
+
+// Components between 0 and 1, for cairo
+func (c Color) RGB() (r, g, b float64, err error) {
+	rgb, err := c.value()
+	return float64(rgb>>16) / 255, float64((rgb>>8)&0xFF) / 255, float64(rgb&0xFF) / 255, err
+}
+
+// As an X pixel value, for the window background
+func (c Color) Pixel() uint32 {
+	rgb, _ := c.value()
+	return 0xFF000000 | rgb
+}
+
+func setColor(W *XWindow, c Color) {
+	// Themes are checked when loaded, anything invalid is black
+	r, g, b, _ := c.RGB()
+	C.cairo_set_source_rgb(W.Cairo, C.double(r), C.double(g), C.double(b))
+}
+
+// Adds a rectangle with the theme's rounded corners to the cairo path
+func roundedRectangle(W *XWindow, x, y, w, h float64) {
+	radius := currentTheme().CornerRadius
+	if radius > w/2 {
+		radius = w / 2
+	}
+	if radius > h/2 {
+		radius = h / 2
+	}
+	if radius <= 0 {
+		C.cairo_rectangle(W.Cairo, C.double(x), C.double(y), C.double(w), C.double(h))
+		return
+	}
+	C.cairo_new_sub_path(W.Cairo)
+	C.cairo_arc(W.Cairo, C.double(x+w-radius), C.double(y+radius), C.double(radius), -math.Pi/2, 0)
+	C.cairo_arc(W.Cairo, C.double(x+w-radius), C.double(y+h-radius), C.double(radius), 0, math.Pi/2)
+	C.cairo_arc(W.Cairo, C.double(x+radius), C.double(y+h-radius), C.double(radius), math.Pi/2, math.Pi)
+	C.cairo_arc(W.Cairo, C.double(x+radius), C.double(y+radius), C.double(radius), math.Pi, 3*math.Pi/2)
+	C.cairo_close_path(W.Cairo)
+}
+
+// Every color of the theme has to parse, and sizes can't be negative
+func validateTheme(t *Theme) error {
+	v := reflect.ValueOf(t).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch field := v.Field(i).Interface().(type) {
+		case Color:
+			if _, _, _, err := field.RGB(); err != nil {
+				return fmt.Errorf("%s: %v", v.Type().Field(i).Tag.Get("json"), err)
+			}
+		case float64:
+			if field < 0 {
+				return fmt.Errorf("%s can't be negative", v.Type().Field(i).Tag.Get("json"))
+			}
+		}
+	}
+	if t.Font == "" {
+		return errors.New("font can't be empty")
+	}
+	return nil
+}
+
+func themePath(name string) string {
+	return filepath.Join(configDir(), ThemesDirName, name+".json")
+}
+
+// A built-in theme, or one from the themes directory of the config, which
+// wins when both have the name
+func loadTheme(name string) (*Theme, error) {
+	data, err := ioutil.ReadFile(themePath(name))
+	if os.IsNotExist(err) {
+		if builtin := builtinThemes[name]; builtin != nil {
+			return builtin(), nil
+		}
+		return nil, fmt.Errorf("There's no theme %q, and no %s", name, themePath(name))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Base string `json:"base"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%s: %v", themePath(name), err)
+	}
+	base := builtinThemes[header.Base]
+	if header.Base == "" {
+		base = darkTheme
+	}
+	if base == nil {
+		return nil, fmt.Errorf("%s: there's no built-in theme %q", themePath(name), header.Base)
+	}
+	Result := base()
+	if err := json.Unmarshal(data, Result); err != nil {
+		return nil, fmt.Errorf("%s: %v", themePath(name), err)
+	}
+	if err := validateTheme(Result); err != nil {
+		return nil, fmt.Errorf("%s: %v", themePath(name), err)
+	}
+	return Result, nil
+}
+
+// The theme the config asks for. Configs from before themes had the fonts in
+// them, which still go for the built-in themes. A theme file sets its own
+func loadConfigTheme(c *Config) (*Theme, error) {
+	Result, err := loadTheme(c.Theme)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(themePath(c.Theme)); os.IsNotExist(err) {
+		if c.Font != "" {
+			Result.Font = c.Font
+		}
+		if c.EmojiFont != "" {
+			Result.EmojiFont = c.EmojiFont
+		}
+	}
+	return Result, nil
+}
+
+// Sends the theme again every time its file changes, until ctx is cancelled.
+// A file with mistakes is reported, and the theme stays as it was
+func watchTheme(ctx context.Context, name string, changed chan<- *Theme) {
+	var lastMod time.Time
+	if info, err := os.Stat(themePath(name)); err == nil {
+		lastMod = info.ModTime()
+	}
+	for sleepContext(ctx, ThemeCheckInterval) {
+		info, err := os.Stat(themePath(name))
+		if err != nil || !info.ModTime().After(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+		t, err := loadTheme(name)
+		if err != nil {
+			showError("Could not reload the theme:", err)
+			continue
+		}
+		select {
+		case changed <- t:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A config file from before themes, with the fonts in it
+const legacyConfig = `{"layout": "tabs", "theme": "light", "font": "Serif 12", "emoji_font": "Twemoji"}`
+
+func TestLegacyFonts(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if err := os.MkdirAll(filepath.Join(configDir(), ThemesDirName), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(configDir(), ConfigFileName), []byte(legacyConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	theme, err := loadConfigTheme(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme.Font != "Serif 12" || theme.EmojiFont != "Twemoji" || theme.Background != lightTheme().Background {
+		t.Errorf("Built-in theme with the old fonts is %+v", theme)
+	}
+
+	// Without them the theme keeps its own
+	config.Font, config.EmojiFont = "", ""
+	if theme, err = loadConfigTheme(config); err != nil {
+		t.Fatal(err)
+	}
+	if theme.Font != lightTheme().Font || theme.EmojiFont != lightTheme().EmojiFont {
+		t.Errorf("Built-in theme without the old fonts has %q and %q", theme.Font, theme.EmojiFont)
+	}
+
+	// A theme file has the last word
+	config.Font, config.EmojiFont = "Serif 12", "Twemoji"
+	if err := ioutil.WriteFile(themePath("light"), []byte(`{"base": "light", "font": "Mono 9"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if theme, err = loadConfigTheme(config); err != nil {
+		t.Fatal(err)
+	}
+	if theme.Font != "Mono 9" || theme.EmojiFont != lightTheme().EmojiFont {
+		t.Errorf("Theme file with the old fonts in the config has %q and %q", theme.Font, theme.EmojiFont)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+)
+
+const MaxThreadAncestors = 50 // stop walking up reply chains after this many tweets
+const MaxThreadDepth = 20     // replies nested deeper than this are not shown
+const ThreadIndent = 20       // pixels of indentation per reply level
+
+type ThreadEntry struct {
+	Info    *TweetInfo
+	Depth   int
+	Focused bool // the tweet the thread was opened from
+}
+
+// Looks for a post in the database, and asks the backend for it if it's not there.
+// backend can be nil when offline. Returns nil if the post couldn't be found
+func loadOrFetchPost(DB *bolt.DB, backend Backend, ID int64) (*Post, error) {
+	p, err := getPost(DB, ID)
+	if err != nil || p != nil || backend == nil {
+		return p, err
+	}
+
+	fetched, err := backend.Post(ID)
+	if err != nil {
+		// Deleted, protected or we're offline. Either way, the thread stops here
+		logWarn("Could not fetch tweet", ID, err)
+		return nil, nil
+	}
+	err = DB.Update(func(Tx *bolt.Tx) error {
+		return storePost(Tx, &fetched)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fetched, nil
+}
+
+// Builds the conversation a tweet belongs to, as a depth-first list of
+// entries starting at the oldest ancestor that could be found
+func buildThread(R Renderer, DB *bolt.DB, backend Backend, ID int64) ([]ThreadEntry, error) {
+	t, err := loadOrFetchPost(DB, backend, ID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, errors.New("Tweet not found")
+	}
+
+	root := t
+	for i := 0; i < MaxThreadAncestors && root.InReplyTo != 0; i++ {
+		parent, err := loadOrFetchPost(DB, backend, root.InReplyTo)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			break
+		}
+		root = parent
+	}
+
+	var Result []ThreadEntry
+	if err := appendThreadReplies(R, DB, root, ID, 0, &Result); err != nil {
+		DestroyThread(Result)
+		return nil, err
+	}
+	return Result, nil
+}
+
+func appendThreadReplies(R Renderer, DB *bolt.DB, t *Post, focusedID int64, depth int, Result *[]ThreadEntry) error {
+	*Result = append(*Result, ThreadEntry{
+		Info:    GenerateTweetInfo(R, t),
+		Depth:   depth,
+		Focused: t.ID == focusedID,
+	})
+	if depth >= MaxThreadDepth {
+		return nil
+	}
+
+	replyIDs, err := getReplyIDs(DB, t.ID)
+	if err != nil {
+		return err
+	}
+	for _, replyID := range replyIDs {
+		reply, err := getPost(DB, replyID)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			continue
+		}
+		if err := appendThreadReplies(R, DB, reply, focusedID, depth+1, Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DestroyThread(thread []ThreadEntry) {
+	for _, e := range thread {
+		DestroyTweetInfo(e.Info)
+	}
+}
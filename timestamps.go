@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// How often the window is redrawn so the tweet ages stay current. Ages are
+// computed at draw time, so nothing else needs regenerating
+const TimestampRefreshInterval = 30 * time.Second
+
+// Twitter sends dates in Ruby's default format, e.g. "Wed Aug 27 13:08:45 +0000 2008"
+func parseTwitterTime(s string) (time.Time, error) {
+	return time.Parse(time.RubyDate, s)
+}
+
+// Short age of a tweet, like "3m", "2h" or "Mar 4". Tweets from previous
+// years also get the year, and anything from the future is shown as "now"
+// so clock skew doesn't produce negative ages
+func relativeAge(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	age := now.Sub(t)
+	switch {
+	case age < 10*time.Second:
+		return "now"
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(age/time.Second))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	}
+
+	local := t.In(now.Location())
+	if local.Year() == now.Year() {
+		return local.Format("Jan 2")
+	}
+	return local.Format("Jan 2 2006")
+}
+
+func absoluteTime(t time.Time) string {
+	return t.Local().Format("Mon Jan 2 2006, 15:04:05 MST")
+}
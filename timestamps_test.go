@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTwitterTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"Wed Aug 27 13:08:45 +0000 2008", time.Date(2008, 8, 27, 13, 8, 45, 0, time.UTC)},
+		{"Wed Aug 27 05:08:45 -0800 2008", time.Date(2008, 8, 27, 13, 8, 45, 0, time.UTC)},
+		{"Wed Aug 27 18:38:45 +0530 2008", time.Date(2008, 8, 27, 13, 8, 45, 0, time.UTC)},
+		// The offset moves it to the previous day in UTC
+		{"Thu Jan 01 02:00:00 +0530 2015", time.Date(2014, 12, 31, 20, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parseTwitterTime(test.in)
+		if err != nil {
+			t.Errorf("parseTwitterTime(%q): %v", test.in, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("parseTwitterTime(%q) = %v, want %v", test.in, got.UTC(), test.want)
+		}
+	}
+
+	for _, in := range []string{"", "2008-08-27T13:08:45Z", "Wed Aug 27 13:08:45 2008"} {
+		if _, err := parseTwitterTime(in); err == nil {
+			t.Errorf("parseTwitterTime(%q) didn't fail", in)
+		}
+	}
+}
+
+func TestRelativeAge(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{-time.Hour, "now"},
+		{0, "now"},
+		{9 * time.Second, "now"},
+		{10 * time.Second, "10s"},
+		{59 * time.Second, "59s"},
+		{time.Minute, "1m"},
+		{59*time.Minute + 59*time.Second, "59m"},
+		{time.Hour, "1h"},
+		{23*time.Hour + 59*time.Minute, "23h"},
+		{24 * time.Hour, "Jun 14"},
+		{200 * 24 * time.Hour, "Nov 28 2019"},
+	}
+	for _, test := range tests {
+		if got := relativeAge(now.Add(-test.age), now); got != test.want {
+			t.Errorf("relativeAge of %v = %q, want %q", test.age, got, test.want)
+		}
+	}
+
+	if got := relativeAge(time.Time{}, now); got != "" {
+		t.Errorf("relativeAge of the zero time = %q, want \"\"", got)
+	}
+}
+
+// Dates are shown in the zone of now, which moves tweets across days
+func TestRelativeAgeZones(t *testing.T) {
+	tweet := time.Date(2020, 6, 14, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"UTC", "Jun 14"},
+		{"America/Los_Angeles", "Jun 14"},
+		{"Asia/Kolkata", "Jun 15"},
+	}
+	for _, test := range tests {
+		location, err := time.LoadLocation(test.zone)
+		if err != nil {
+			t.Skip("No time zone database:", err)
+		}
+		now := tweet.Add(3 * 24 * time.Hour).In(location)
+		if got := relativeAge(tweet, now); got != test.want {
+			t.Errorf("relativeAge in %s = %q, want %q", test.zone, got, test.want)
+		}
+	}
+}
+
+func TestAbsoluteTime(t *testing.T) {
+	saved := time.Local
+	defer func() { time.Local = saved }()
+
+	tweet, err := parseTwitterTime("Wed Aug 27 13:08:45 +0000 2008")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"UTC", "Wed Aug 27 2008, 13:08:45 UTC"},
+		{"America/Los_Angeles", "Wed Aug 27 2008, 06:08:45 PDT"},
+		{"Asia/Kolkata", "Wed Aug 27 2008, 18:38:45 IST"},
+		{"Pacific/Auckland", "Thu Aug 28 2008, 01:08:45 NZST"},
+	}
+	for _, test := range tests {
+		location, err := time.LoadLocation(test.zone)
+		if err != nil {
+			t.Skip("No time zone database:", err)
+		}
+		time.Local = location
+		if got := absoluteTime(tweet); got != test.want {
+			t.Errorf("absoluteTime in %s = %q, want %q", test.zone, got, test.want)
+		}
+	}
+}
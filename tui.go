@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"html"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The frontend for terminals, for when there's no X server. It shows the same
+// columns as the window, one at a time, and draws with ANSI escape codes
+type Terminal struct {
+	Width    int
+	Height   int
+	DB       *bolt.DB
+	Backend  Backend
+	Filters  *Filters
+	Columns  []*Column
+	Active   int
+	Selected *TweetInfo
+	Top      *TweetInfo // first post on screen
+	Status   string
+	Input    *TerminalInput
+}
+
+// A line being typed at the bottom of the screen
+type TerminalInput struct {
+	Label    string
+	Text     []rune
+	OnSubmit func(text string)
+}
+
+// Posts are drawn straight from their markup, there's nothing to lay out
+func (T *Terminal) LayoutPost(t *TweetInfo) {}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func terminalSize() (int, int) {
+	size, err := stty("size")
+	if err == nil {
+		var rows, cols int
+		if _, err := fmt.Sscan(size, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return cols, rows
+		}
+	}
+	return 80, 24
+}
+
+type markupStyle struct {
+	Bold   bool
+	Italic bool
+	Dim    bool
+	Color  string // escape code setting the foreground, empty for the default
+}
+
+func styleEscape(s markupStyle) string {
+	Result := "\x1b[0m"
+	if s.Bold {
+		Result += "\x1b[1m"
+	}
+	if s.Dim {
+		Result += "\x1b[2m"
+	}
+	if s.Italic {
+		Result += "\x1b[3m"
+	}
+	return Result + s.Color
+}
+
+// Converts a pango color like #5C5 or #55CC55 to a 24 bit terminal color
+func colorEscape(color string) string {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) == 3 {
+		color = string([]byte{color[0], color[0], color[1], color[1], color[2], color[2]})
+	}
+	rgb, err := strconv.ParseUint(color, 16, 32)
+	if len(color) != 6 || err != nil {
+		return ""
+	}
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", rgb>>16, (rgb>>8)&0xFF, rgb&0xFF)
+}
+
+// Value of an attribute in a tag like <span color='#777' size='x-large'>
+func tagAttribute(tag, name string) string {
+	i := strings.Index(tag, name+"=")
+	if i < 0 || i+len(name)+2 > len(tag) {
+		return ""
+	}
+	rest := tag[i+len(name)+1:]
+	quote := rest[0]
+	end := strings.IndexByte(rest[1:], quote)
+	if end < 0 {
+		return ""
+	}
+	return rest[1 : end+1]
+}
+
+// Terminal cells a character takes. East Asian wide characters and emoji take
+// two, marks combining with the character before them none
+func cellWidth(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf): // also joiners and variation selectors
+		return 0
+	case r >= 0x1F3FB && r <= 0x1F3FF: // skin tones, drawn over the emoji before them
+		return 0
+	case r >= 0x1100 && r <= 0x115F, // Hangul Jamo
+		r >= 0x2E80 && r <= 0x303E, // CJK radicals and punctuation
+		r >= 0x3041 && r <= 0x33FF, // Kana, CJK compatibility
+		r >= 0x3400 && r <= 0x4DBF, // CJK extension A
+		r >= 0x4E00 && r <= 0x9FFF, // CJK unified ideographs
+		r >= 0xA000 && r <= 0xA4CF, // Yi
+		r >= 0xAC00 && r <= 0xD7A3, // Hangul syllables
+		r >= 0xF900 && r <= 0xFAFF, // CJK compatibility ideographs
+		r >= 0xFE30 && r <= 0xFE4F, // CJK compatibility forms
+		r >= 0xFF00 && r <= 0xFF60, // Fullwidth forms
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1F64F, // Emoji
+		r >= 0x1F900 && r <= 0x1F9FF,
+		r >= 0x1F680 && r <= 0x1F6FF,
+		r >= 0x20000 && r <= 0x3FFFD: // CJK extensions B and up
+		return 2
+	}
+	return 1
+}
+
+// Translates the pango markup GenerateTweetInfo produces into lines of at
+// most width terminal cells, styled with escape codes
+func markupLines(markup string, width int) []string {
+	var lines []string
+	var line strings.Builder
+	lineLen := 0
+	styles := []markupStyle{{}}
+	endLine := func() {
+		line.WriteString("\x1b[0m")
+		lines = append(lines, line.String())
+		line.Reset()
+		line.WriteString(styleEscape(styles[len(styles)-1]))
+		lineLen = 0
+	}
+
+	for len(markup) > 0 {
+		if markup[0] == '<' {
+			end := strings.IndexByte(markup, '>')
+			if end < 0 {
+				break
+			}
+			tag := markup[1:end]
+			markup = markup[end+1:]
+			if strings.HasPrefix(tag, "/") {
+				if len(styles) > 1 {
+					styles = styles[:len(styles)-1]
+				}
+			} else {
+				style := styles[len(styles)-1]
+				switch {
+				case tag == "b":
+					style.Bold = true
+				case tag == "i":
+					style.Italic = true
+				case tag == "small":
+					style.Dim = true
+				case strings.HasPrefix(tag, "span"):
+					if color := tagAttribute(tag, "color"); color != "" {
+						style.Color = colorEscape(color)
+					}
+				}
+				styles = append(styles, style)
+			}
+			line.WriteString(styleEscape(styles[len(styles)-1]))
+			continue
+		}
+
+		// Text up to the next tag
+		end := strings.IndexByte(markup, '<')
+		if end < 0 {
+			end = len(markup)
+		}
+		text := html.UnescapeString(markup[:end])
+		markup = markup[end:]
+		for _, r := range text {
+			if r == '\n' {
+				endLine()
+				continue
+			}
+			// Direction marks, see withDirection. Terminals lay out bidi text their own way
+			if r == '\u200e' || r == '\u200f' {
+				continue
+			}
+			w := cellWidth(r)
+			if lineLen+w > width && lineLen > 0 {
+				endLine()
+			}
+			line.WriteRune(r)
+			lineLen += w
+		}
+	}
+	endLine()
+	return lines
+}
+
+func activeColumn(T *Terminal) *Column {
+	if len(T.Columns) == 0 {
+		return nil
+	}
+	return T.Columns[T.Active]
+}
+
+// Selects the newest post of the active column
+func resetSelection(T *Terminal) {
+	T.Selected = nil
+	T.Top = nil
+	if c := activeColumn(T); c != nil {
+		T.Selected = c.Tweets.Newest
+		T.Top = c.Tweets.Newest
+	}
+}
+
+func postLines(T *Terminal, t *TweetInfo) []string {
+	lines := markupLines(t.Markup, T.Width-2)
+	if age := relativeAge(t.CreatedAt, time.Now()); age != "" {
+		lines[0] += " \x1b[2m· " + age + "\x1b[0m"
+	}
+	return lines
+}
+
+// Scrolls so the selected post is completely on screen
+func scrollToSelection(T *Terminal) {
+	if T.Selected == nil {
+		return
+	}
+	// Selected above the top, it becomes the top
+	for t := T.Top; t != nil; t = t.Newer {
+		if t == T.Selected {
+			T.Top = T.Selected
+			return
+		}
+	}
+	for {
+		used := 0
+		for t := T.Top; t != nil; t = t.Older {
+			used += len(postLines(T, t)) + 1
+			if t == T.Selected {
+				break
+			}
+		}
+		if used <= T.Height-2 || T.Top == T.Selected {
+			return
+		}
+		T.Top = T.Top.Older
+	}
+}
+
+func drawTerminal(T *Terminal) {
+	var out strings.Builder
+	out.WriteString("\x1b[H\x1b[2J")
+
+	// Tabs
+	for i, c := range T.Columns {
+		title := " " + timelineTitle(c.Source)
+		if c.Unread > 0 {
+			title += fmt.Sprintf(" (%d)", c.Unread)
+		}
+		title += " "
+		if i == T.Active {
+			out.WriteString("\x1b[7m" + title + "\x1b[0m")
+		} else {
+			out.WriteString(title)
+		}
+	}
+	out.WriteString("\r\n")
+
+	row := 1
+	for t := T.Top; t != nil && row < T.Height-1; t = t.Older {
+		marker := "  "
+		if t == T.Selected {
+			marker = "\x1b[7m \x1b[0m "
+		}
+		for _, line := range postLines(T, t) {
+			if row >= T.Height-1 {
+				break
+			}
+			out.WriteString(marker + line + "\r\n")
+			row++
+		}
+		out.WriteString("\r\n")
+		row++
+	}
+
+	// Status line, or the line being typed
+	out.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[2K", T.Height))
+	if T.Input != nil {
+		out.WriteString(T.Input.Label + " " + string(T.Input.Text))
+	} else if T.Status != "" {
+		out.WriteString(T.Status)
+	} else if items := currentBanners(time.Now()); len(items) > 0 {
+		out.WriteString("\x1b[41m" + items[len(items)-1].Text + "\x1b[0m")
+	} else {
+		out.WriteString("\x1b[2mj/k move  h/l column  f favourite  b boost  r reply  m mute  q quit\x1b[0m")
+	}
+	os.Stdout.WriteString(out.String())
+}
+
+func reloadTerminalColumns(T *Terminal) {
+	for _, c := range T.Columns {
+		if err := ReloadColumn(T, T.DB, T.Filters, c); err != nil {
+			T.Status = "Could not load " + timelineTitle(c.Source) + ": " + err.Error()
+		}
+	}
+	resetSelection(T)
+}
+
+// Reads keys from the terminal, turning escape sequences into names like "up"
+func readKeys(keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		switch s := string(buf[:n]); s {
+		case "\x1b[A":
+			keys <- "up"
+		case "\x1b[B":
+			keys <- "down"
+		case "\x1b[C":
+			keys <- "right"
+		case "\x1b[D":
+			keys <- "left"
+		default:
+			for len(s) > 0 {
+				r, size := utf8.DecodeRuneInString(s)
+				keys <- string(r)
+				s = s[size:]
+			}
+		}
+	}
+}
+
+func handleInputKey(T *Terminal, key string) {
+	switch key {
+	case "\x1b":
+		T.Input = nil
+	case "\r", "\n":
+		input := T.Input
+		T.Input = nil
+		if text := strings.TrimSpace(string(input.Text)); text != "" {
+			input.OnSubmit(text)
+		}
+	case "\x7f", "\b":
+		if len(T.Input.Text) > 0 {
+			T.Input.Text = T.Input.Text[:len(T.Input.Text)-1]
+		}
+	default:
+		if r, _ := utf8.DecodeRuneInString(key); r >= ' ' {
+			T.Input.Text = append(T.Input.Text, r)
+		}
+	}
+}
+
+// Returns false to quit
+func handleTerminalKey(T *Terminal, key string) bool {
+	if T.Input != nil {
+		handleInputKey(T, key)
+		return true
+	}
+	T.Status = ""
+	t := T.Selected
+
+	switch key {
+	case "q":
+		return false
+	case "j", "down":
+		if t != nil && t.Older != nil {
+			T.Selected = t.Older
+		}
+	case "k", "up":
+		if t != nil && t.Newer != nil {
+			T.Selected = t.Newer
+		}
+	case "h", "left":
+		if len(T.Columns) > 0 {
+			T.Active = (T.Active + len(T.Columns) - 1) % len(T.Columns)
+			resetSelection(T)
+		}
+	case "l", "right", "\t":
+		if len(T.Columns) > 0 {
+			T.Active = (T.Active + 1) % len(T.Columns)
+			resetSelection(T)
+		}
+	case "f":
+		if t != nil {
+			T.Status = "Favourited"
+			if err := favouritePost(T.Backend, actionTarget(t)); err != nil {
+				T.Status = err.Error()
+			}
+		}
+	case "b":
+		if t != nil {
+			T.Status = "Boosted"
+			if err := boostPost(T.Backend, actionTarget(t)); err != nil {
+				T.Status = err.Error()
+			}
+		}
+	case "r":
+		if t != nil {
+			// New posts reload the columns while the reply is typed, which frees t
+			to := actionTarget(t)
+			T.Input = &TerminalInput{Label: "Reply to @" + to.Author.ScreenName + ":", OnSubmit: func(text string) {
+				T.Status = "Replied"
+				if err := replyToPost(T.Backend, to, text); err != nil {
+					T.Status = err.Error()
+				}
+			}}
+		}
+	case "m":
+		if t != nil {
+			muteUser(T.Filters, t.ScreenName)
+			if err := applyFilters(T, T.DB, T.Filters, T.Columns); err != nil {
+				T.Status = err.Error()
+			}
+			resetSelection(T)
+		}
+	}
+	scrollToSelection(T)
+	return true
+}
+
+// gowitt tui
+func tuiCommand(args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	DB, a, err := openCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer DB.Close()
+
+	// Errors go to the log file and the status line instead of over the screen
+	if err := openLogFile(parseLogLevel(config.LogLevel)); err == nil {
+		logger.Lock()
+		logger.Quiet = true
+		logger.Unlock()
+		defer closeLogFile()
+	}
+	if theme, err := loadConfigTheme(config); err != nil {
+		logWarn("Could not load the theme:", err)
+	} else {
+		setTheme(theme)
+	}
+
+	T := &Terminal{DB: DB, Backend: newBackend(a)}
+	T.Width, T.Height = terminalSize()
+	if T.Filters, err = loadFilters(DB); err != nil {
+		return err
+	}
+	sources := backendColumns(T.Backend, config.Columns)
+	for _, source := range sources {
+		column, err := NewColumn(T, DB, T.Filters, source)
+		if err != nil {
+			return err
+		}
+		T.Columns = append(T.Columns, column)
+	}
+	resetSelection(T)
+
+	saved, err := stty("-g")
+	if err != nil {
+		return fmt.Errorf("Not a terminal: %v", err)
+	}
+	stty("-icanon", "-echo", "min", "1")
+	// Alternate screen, hidden cursor
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+		stty(saved)
+	}()
+
+	keys := make(chan string, 16)
+	go readKeys(keys)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// Errors show up as banners on the status line
+	updated := make(chan TimelineUpdate, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.PollMinutes > 0 {
+		go pollTimelines(ctx, DB, T.Backend, nil, accountKey(a), sources, time.Duration(config.PollMinutes)*time.Minute,
+			newNotifier(a.ScreenName, config), updated)
+	}
+
+	for {
+		drawTerminal(T)
+		select {
+		case key, ok := <-keys:
+			if !ok || !handleTerminalKey(T, key) {
+				return nil
+			}
+		case <-updated:
+			// Reloading loses the selection, don't do it under the user's feet
+			if c := activeColumn(T); c == nil || T.Selected == nil || T.Selected == c.Tweets.Newest {
+				reloadTerminalColumns(T)
+			}
+		case sig := <-signals:
+			if sig != syscall.SIGWINCH {
+				return nil
+			}
+			T.Width, T.Height = terminalSize()
+			scrollToSelection(T)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var escapes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestMarkupLinesWidth(t *testing.T) {
+	tests := []struct {
+		markup string
+		width  int
+		want   []string
+	}{
+		{"abcdef", 4, []string{"abcd", "ef"}},
+		{"<b>ab</b>cd\nef", 4, []string{"abcd", "ef"}},
+		// Wide characters take two cells and aren't split across lines
+		{"日本語のテキスト", 6, []string{"日本語", "のテキ", "スト"}},
+		{"ab日本", 3, []string{"ab", "日", "本"}},
+		{"hi 👋🏽 there", 5, []string{"hi 👋🏽", " ther", "e"}},
+		// Combining marks and joiners stay with the character before them
+		{"cafés", 4, []string{"café", "s"}},
+		{"👩‍💻ab", 4, []string{"👩‍💻", "ab"}},
+		{"‏שלום", 2, []string{"של", "ום"}},
+		{"&lt;3 &amp;", 3, []string{"<3 ", "&"}},
+	}
+	for _, test := range tests {
+		lines := markupLines(test.markup, test.width)
+		var got []string
+		for _, line := range lines {
+			got = append(got, escapes.ReplaceAllString(line, ""))
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("markupLines(%q, %d) = %q, want %q", test.markup, test.width, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("markupLines(%q, %d) = %q, want %q", test.markup, test.width, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestReplyOutlivesReload(t *testing.T) {
+	b, f := newFakeInstance(t)
+	boost := &TweetInfo{ID: 900, ShownID: 103, ScreenName: "ann", RetweetedBy: "bob"}
+	T := &Terminal{Width: 80, Height: 24, Backend: b, Selected: boost, Top: boost}
+
+	handleTerminalKey(T, "r")
+	if T.Input == nil {
+		t.Fatal("r didn't ask for the reply")
+	}
+	// What a reload does to the posts it drops
+	*boost = TweetInfo{}
+	for _, key := range []string{"h", "i", "\r"} {
+		handleTerminalKey(T, key)
+	}
+	if T.Status != "Replied" {
+		t.Fatalf("replying said %q", T.Status)
+	}
+	if _, params := lastRequest(f); params.Get("status") != "@ann hi" || params.Get("in_reply_to_id") != "103" {
+		t.Errorf("reply sent %v", params)
+	}
+}
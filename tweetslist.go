@@ -11,9 +11,10 @@ import "C"
 
 import (
 	"fmt"
-	"github.com/ChimeraCoder/anaconda"
 	"html"
 	"strings"
+	"time"
+	"unicode"
 )
 
 func Assert(b bool) {
@@ -22,178 +23,303 @@ func Assert(b bool) {
 	}
 }
 
-var layoutsCache struct {
-	Layouts []*C.PangoLayout
-	Cairo   *C.cairo_t
+type TweetInfo struct {
+	ID          int64
+	ShownID     int64  // ID of the original tweet for retweets, ID otherwise
+	Text        string // of the shown tweet
+	UserImage   string
+	ScreenName  string // author of the shown tweet, the original one for retweets
+	RetweetedBy string // empty if not a retweet
+	Hashtags    []string
+	CreatedAt   time.Time // zero if twitter sent something we couldn't parse
+	Markup      string    // pango markup of everything shown for the tweet
+	RTL         bool      // the shown tweet is in a right to left script
+	Older       *TweetInfo
+	Newer       *TweetInfo
+	Layout      *C.PangoLayout // nil for renderers that don't use pango
+	layouts     *LayoutFactory // that made Layout
 }
 
-func InitLayoutsCache(cairo *C.cairo_t) {
-	layoutsCache.Cairo = cairo
+// A frontend showing timelines. The X11 window lays out each post with pango
+// up front, the terminal translates the markup when drawing
+type Renderer interface {
+	LayoutPost(t *TweetInfo)
 }
 
-func getLayout() *C.PangoLayout {
-	if len(layoutsCache.Layouts) == 0 {
-		return C.pango_cairo_create_layout(layoutsCache.Cairo)
+func GenerateTweetInfo(R Renderer, t *Post) *TweetInfo {
+	shown := shownPost(t)
+	Result := TweetInfo{
+		ID:         t.ID,
+		ShownID:    shown.ID,
+		Text:       shown.Text,
+		UserImage:  shown.Author.AvatarURL,
+		ScreenName: shown.Author.ScreenName,
+		Hashtags:   postEntities(shown, HashtagEntity),
+		CreatedAt:  t.CreatedAt,
 	}
-	result := layoutsCache.Layouts[len(layoutsCache.Layouts)-1]
-	layoutsCache.Layouts = layoutsCache.Layouts[:len(layoutsCache.Layouts)-1]
-	return result
+	Result.RTL = isRTL(shown.Text)
+	Result.Markup = withDirection(tweetMarkup(t), Result.RTL)
+	if t.Reblog != nil {
+		Result.RetweetedBy = t.Author.ScreenName
+	}
+	R.LayoutPost(&Result)
+	return &Result
 }
 
-func recycleLayout(l *C.PangoLayout) {
-	layoutsCache.Layouts = append(layoutsCache.Layouts, l)
+// Whether text reads right to left, going by its first letter as the unicode
+// bidi algorithm does. Mentions and links don't count, a reply to @someone in
+// arabic is still arabic
+func isRTL(text string) bool {
+	for _, word := range strings.Fields(text) {
+		if strings.HasPrefix(word, "@") || strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+			continue
+		}
+		for _, r := range word {
+			if unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko, unicode.Samaritan, unicode.Mandaic, unicode.Adlam) {
+				return true
+			}
+			if unicode.IsLetter(r) {
+				return false
+			}
+		}
+	}
+	return false
 }
 
-type TweetInfo struct {
-	ID        int64
-	Text      string
-	UserImage string
-	Older     *TweetInfo
-	Newer     *TweetInfo
-	Layout    *C.PangoLayout
+// Starts every paragraph with a direction mark. Pango picks the direction and
+// alignment of each paragraph by its first letter, so names, counts and text
+// in other scripts would otherwise go every which way inside one tweet
+func withDirection(markup string, rtl bool) string {
+	mark := "\u200e"
+	if rtl {
+		mark = "\u200f"
+	}
+	return mark + strings.Replace(markup, "\n", "\n"+mark, -1)
+}
+
+// Takes out what withDirection put in, for the text copied from a tweet
+func withoutDirection(text string) string {
+	return strings.NewReplacer("\u200e", "", "\u200f", "").Replace(text)
 }
 
-func GenerateTweetInfo(W *XWindow, t *anaconda.Tweet) *TweetInfo {
+func tweetMarkup(t *Post) string {
+	th := currentTheme()
+	shown := shownPost(t)
 	var text string
-	if t.RetweetedStatus != nil {
-		text = fmt.Sprintf("<i><small>%s</small></i> <span color='#5C5'>⇄</span> <b>%s</b> <small>@%s</small>\n%s", html.EscapeString(t.User.Name),
-			t.RetweetedStatus.User.Name, t.RetweetedStatus.User.ScreenName,
-			html.EscapeString(t.RetweetedStatus.Text))
+	if t.Reblog != nil {
+		text = fmt.Sprintf("<i><small>%s</small></i> <span color='%s'>⇄</span> <b>%s</b> <small>@%s</small>\n%s", html.EscapeString(t.Author.Name), th.Boost,
+			t.Reblog.Author.Name, t.Reblog.Author.ScreenName,
+			html.EscapeString(t.Reblog.Text))
 
 	} else {
 		text = fmt.Sprintf("<b>%s</b> <small>@%s</small>\n%s",
-			html.EscapeString(t.User.Name),
-			t.User.ScreenName,
+			html.EscapeString(t.Author.Name),
+			t.Author.ScreenName,
 			html.EscapeString(t.Text))
 	}
 	text = strings.Replace(text, "&amp;", "&", -1)
-	text = replaceURLS(text, func(s string) string { return "<span color='#88F'>" + s + "</span>" })
-	text += "\n<span size='x-large' color='#777'>↶     "
+	text = replaceURLS(text, func(s string) string { return "<span color='" + string(th.Link) + "'>" + s + "</span>" })
+	text += "\n<span size='x-large' color='" + string(th.SecondaryText) + "'>↶     "
 
 	// Add favorite icon
-	favoriteColor := "#777"
+	favoriteColor := th.SecondaryText
 	favoriteText := "      "
-	favoriteCount := t.FavoriteCount
-	if t.RetweetedStatus != nil {
-		favoriteCount = t.RetweetedStatus.FavoriteCount
-	}
+	favoriteCount := shown.FavouriteCount
 	if favoriteCount > 0 {
 		favoriteText = fmt.Sprintf("<span size='medium'> %-4d </span>", favoriteCount)
 	}
-	if t.Favorited {
-		favoriteColor = "#D22"
+	if t.Favourited {
+		favoriteColor = th.Favourite
 	}
 	text += fmt.Sprintf("<span color='%s'>❤</span><span size='medium'>%s</span>", favoriteColor, favoriteText)
 
 	// Add RT icon
-	retweetColor := "#777"
+	retweetColor := th.SecondaryText
 	retweetText := "      "
-	if t.Retweeted {
-		retweetColor = "#3D3"
-	}
-	retweetCount := t.RetweetCount
-	if t.RetweetedStatus != nil {
-		retweetCount = t.RetweetedStatus.RetweetCount
+	if t.Reblogged {
+		retweetColor = th.Boost
 	}
+	retweetCount := shown.ReblogCount
 	if retweetCount > 0 {
 		retweetText = fmt.Sprintf("<span size='medium'> %-4d </span>", retweetCount)
 	}
 	text += fmt.Sprintf("<span color='%s'>⇄</span>%s", retweetColor, retweetText)
 
 	// Add "more options" icon
-	text += "<span color='#777'>…</span></span>"
+	text += "<span color='" + string(th.SecondaryText) + "'>…</span></span>"
+	return text
+}
 
-	userImageUrl := t.User.ProfileImageURL
-	if t.RetweetedStatus != nil {
-		userImageUrl = t.RetweetedStatus.User.ProfileImageURL
+// Safe to call from any goroutine, the layout factory does the locking
+func (W *XWindow) LayoutPost(t *TweetInfo) {
+	t.Layout = markupLayout(W.Layouts, t.Markup)
+	t.layouts = W.Layouts
+}
+
+func DestroyTweetInfo(t *TweetInfo) {
+	if t.Layout != nil {
+		recycleLayout(t.layouts, t.Layout)
 	}
+	*t = TweetInfo{}
+}
 
-	errorText := "[[INTERNAL ERROR, COULD NOT PROCESS TWEET]]"
+// A bounded window over a timeline, newest first. Tweets are linked through
+// their Newer and Older fields. When it grows past MaxTweets the tweets
+// farthest from CenterTweet are dropped, so the ones around it stay
+type TweetsBuffer struct {
+	MaxTweets   int
+	CenterTweet *TweetInfo // nil only when the buffer is empty
+	Oldest      *TweetInfo
+	Newest      *TweetInfo
+	NewerCnt    int // tweets newer than CenterTweet
+	OlderCnt    int // tweets older than CenterTweet
+}
 
-	var strippedText *C.char = nil //&outputText[0]
+func NewTweetsBuffer(maxTweets int) *TweetsBuffer {
+	Assert(maxTweets > 0)
+	return &TweetsBuffer{MaxTweets: maxTweets}
+}
 
-	// Generate tweet layout
-	if C.pango_parse_markup(C.CString(text), -1, 0,
-		&W.AttrList,
-		&strippedText, nil, nil) != 1 {
-		fmt.Println("error parsing", text)
-		strippedText = C.CString(errorText)
+func TweetsCount(b *TweetsBuffer) int {
+	if b.CenterTweet == nil {
+		return 0
 	}
+	return b.NewerCnt + 1 + b.OlderCnt
+}
 
-	layout := getLayout()
-	C.pango_layout_set_font_description(layout, W.FontDesc)
-	C.pango_layout_set_attributes(layout, W.AttrList)
-	C.pango_layout_set_text(layout, strippedText, -1)
+// Destroys all tweets in the buffer, leaving it empty
+func ClearTweetsBuffer(b *TweetsBuffer) {
+	for t := b.Newest; t != nil; {
+		older := t.Older
+		DestroyTweetInfo(t)
+		t = older
+	}
+	*b = TweetsBuffer{MaxTweets: b.MaxTweets}
+}
 
-	Result := TweetInfo{
-		ID:        t.Id,
-		Text:      t.Text,
-		UserImage: userImageUrl,
-		Layout:    layout,
+// Puts a tweet in its place by ID, which can be anywhere, as when a gap is
+// backfilled. Returns false if it wasn't kept, because the buffer already had
+// it or it was the one evicted. The buffer owns the tweet either way
+func InsertTweet(b *TweetsBuffer, t *TweetInfo) bool {
+	t.Newer, t.Older = nil, nil
+	if b.CenterTweet == nil {
+		b.Oldest, b.Newest, b.CenterTweet = t, t, t
+		return true
 	}
 
-	return &Result
+	// First tweet older than t, nil if t is the oldest
+	older := b.Newest
+	for older != nil && older.ID > t.ID {
+		older = older.Older
+	}
+	if older != nil && older.ID == t.ID {
+		DestroyTweetInfo(t)
+		return false
+	}
+	if older == nil {
+		t.Newer = b.Oldest
+		b.Oldest.Older = t
+		b.Oldest = t
+	} else {
+		t.Older = older
+		t.Newer = older.Newer
+		if older.Newer != nil {
+			older.Newer.Older = t
+		} else {
+			b.Newest = t
+		}
+		older.Newer = t
+	}
+	if t.ID > b.CenterTweet.ID {
+		b.NewerCnt++
+	} else {
+		b.OlderCnt++
+	}
+
+	kept := true
+	for TweetsCount(b) > b.MaxTweets {
+		evicted := b.Oldest
+		if b.NewerCnt > b.OlderCnt {
+			evicted = b.Newest
+		}
+		if evicted == t {
+			kept = false
+		}
+		RemoveTweet(b, evicted)
+	}
+	return kept
 }
 
-func DestroyTweetInfo(t *TweetInfo) {
-	recycleLayout(t.Layout)
-	*t = TweetInfo{}
+// Adds a tweet newer than all the others
+func AddNewer(b *TweetsBuffer, t *TweetInfo) bool {
+	Assert(b.Newest == nil || t.ID > b.Newest.ID)
+	return InsertTweet(b, t)
 }
 
-type TweetsBuffer struct {
-	MaxTweets   int
-	CenterTweet *TweetInfo
-	Oldest      *TweetInfo
-	Newest      *TweetInfo
-	NewerCnt    int
-	OlderCnt    int
-}
-
-func AddNewer(b *TweetsBuffer, t TweetInfo) {
-	Assert(t.ID > b.Newest.ID)
-
-	t.Older = b.Newest
-	b.Newest.Newer = &t
-	b.Newest = &t
-	b.NewerCnt++
-	if b.NewerCnt > b.MaxTweets {
-		// Evict oldest
-		oldest := b.Oldest
-		b.Oldest = b.Oldest.Newer
-		b.Oldest.Older = nil
+// Adds a tweet older than all the others
+func AddOlder(b *TweetsBuffer, t *TweetInfo) bool {
+	Assert(b.Oldest == nil || t.ID < b.Oldest.ID)
+	return InsertTweet(b, t)
+}
+
+// Takes a tweet out of the buffer and destroys it. When it's the center tweet
+// the next older one becomes the center, or the next newer if there's none
+func RemoveTweet(b *TweetsBuffer, t *TweetInfo) {
+	switch {
+	case t == b.CenterTweet && t.Older != nil:
+		b.CenterTweet = t.Older
+		b.OlderCnt--
+	case t == b.CenterTweet && t.Newer != nil:
+		b.CenterTweet = t.Newer
+		b.NewerCnt--
+	case t == b.CenterTweet:
+		b.CenterTweet = nil
+	case t.ID > b.CenterTweet.ID:
+		b.NewerCnt--
+	default:
 		b.OlderCnt--
-		Assert(b.OlderCnt > 0)
-		DestroyTweetInfo(oldest)
 	}
-}
 
-func AddOlder(b *TweetsBuffer, t TweetInfo) {
-	Assert(t.ID < b.Oldest.ID)
+	if t.Newer != nil {
+		t.Newer.Older = t.Older
+	} else {
+		b.Newest = t.Older
+	}
+	if t.Older != nil {
+		t.Older.Newer = t.Newer
+	} else {
+		b.Oldest = t.Newer
+	}
+	DestroyTweetInfo(t)
+}
 
-	t.Newer = b.Oldest
-	b.Oldest.Older = &t
-	b.Oldest = &t
-	b.OlderCnt++
-	if b.OlderCnt > b.MaxTweets {
-		// Evict newest
-		newest := b.Newest
-		b.Newest = b.Newest.Older
-		b.Newest.Newer = nil
-		b.NewerCnt--
-		Assert(b.NewerCnt > 0)
-		DestroyTweetInfo(newest)
+// Makes a tweet of the buffer its center tweet
+func centerTweet(b *TweetsBuffer, t *TweetInfo) {
+	positions := 0
+	for u := b.CenterTweet; u != nil && u != t && u.ID < t.ID; u = u.Newer {
+		positions++
+	}
+	for u := b.CenterTweet; u != nil && u != t && u.ID > t.ID; u = u.Older {
+		positions--
 	}
+	MoveCenterTweet(b, positions)
 }
 
-func MoveCenterTweet(b *TweetsBuffer, positions int) {
-	for positions > 0 {
+// Moves the center tweet by that many positions, positive towards newer ones.
+// It stops at either end, and returns how many positions it actually moved
+func MoveCenterTweet(b *TweetsBuffer, positions int) int {
+	moved := 0
+	for ; positions > 0 && b.CenterTweet != nil && b.CenterTweet.Newer != nil; positions-- {
 		b.CenterTweet = b.CenterTweet.Newer
-		positions--
-		Assert(b.CenterTweet != nil)
+		b.NewerCnt--
+		b.OlderCnt++
+		moved++
 	}
-	for positions < 0 {
+	for ; positions < 0 && b.CenterTweet != nil && b.CenterTweet.Older != nil; positions++ {
 		b.CenterTweet = b.CenterTweet.Older
-		positions++
-		Assert(b.CenterTweet != nil)
+		b.NewerCnt++
+		b.OlderCnt--
+		moved--
 	}
+	return moved
 }
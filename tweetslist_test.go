@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"testing/quick"
+)
+
+// What has to hold for a buffer after anything was done to it
+func checkTweetsBuffer(b *TweetsBuffer) error {
+	count, newer := 0, 0
+	for t := b.Newest; t != nil; t = t.Older {
+		if t.Older != nil && (t.Older.ID >= t.ID || t.Older.Newer != t) {
+			return fmt.Errorf("%d is followed by %d", t.ID, t.Older.ID)
+		}
+		if t.Older == nil && b.Oldest != t {
+			return fmt.Errorf("Oldest is %v, the list ends at %d", b.Oldest, t.ID)
+		}
+		if b.CenterTweet != nil && t.ID > b.CenterTweet.ID {
+			newer++
+		}
+		count++
+	}
+	if count == 0 && b.Oldest != nil {
+		return fmt.Errorf("Empty, with oldest %d", b.Oldest.ID)
+	}
+	if (b.CenterTweet == nil) != (count == 0) {
+		return fmt.Errorf("Center %v with %d tweets", b.CenterTweet, count)
+	}
+	if count > 0 && (b.NewerCnt+1+b.OlderCnt != count || b.NewerCnt != newer) {
+		return fmt.Errorf("%d newer and %d older than the center, with %d tweets and %d newer", b.NewerCnt, b.OlderCnt, count, newer)
+	}
+	if count != TweetsCount(b) || count > b.MaxTweets {
+		return fmt.Errorf("%d tweets, TweetsCount %d, at most %d", count, TweetsCount(b), b.MaxTweets)
+	}
+	return nil
+}
+
+func nthTweet(b *TweetsBuffer, n int) *TweetInfo {
+	t := b.Newest
+	for ; n > 0; n-- {
+		t = t.Older
+	}
+	return t
+}
+
+// Each op inserts, removes or moves the center, going by its value
+func TestTweetsBufferOps(t *testing.T) {
+	check := func(maxTweets uint8, ops []uint16) bool {
+		b := NewTweetsBuffer(int(maxTweets%12) + 1)
+		for i, op := range ops {
+			arg := int(op / 3)
+			switch op % 3 {
+			case 0:
+				InsertTweet(b, &TweetInfo{ID: int64(arg%64 + 1)})
+			case 1:
+				if n := TweetsCount(b); n > 0 {
+					RemoveTweet(b, nthTweet(b, arg%n))
+				}
+			case 2:
+				positions := arg%21 - 10
+				before := b.NewerCnt
+				if moved := MoveCenterTweet(b, positions); before-b.NewerCnt != moved {
+					t.Logf("Moved %d positions, newer went from %d to %d", moved, before, b.NewerCnt)
+					return false
+				}
+			}
+			if err := checkTweetsBuffer(b); err != nil {
+				t.Logf("After op %d (%d): %v", i, op, err)
+				return false
+			}
+		}
+		ClearTweetsBuffer(b)
+		return checkTweetsBuffer(b) == nil
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertTweet(t *testing.T) {
+	b := NewTweetsBuffer(3)
+	for _, ID := range []int64{10, 30, 20} {
+		if !InsertTweet(b, &TweetInfo{ID: ID}) {
+			t.Errorf("%d wasn't kept", ID)
+		}
+	}
+	if InsertTweet(b, &TweetInfo{ID: 20}) {
+		t.Error("A tweet the buffer had was kept")
+	}
+	// The center is the first tweet, 10, so the newest goes to make room
+	if !InsertTweet(b, &TweetInfo{ID: 5}) || b.Newest.ID != 20 || b.Oldest.ID != 5 {
+		t.Errorf("Kept %d to %d", b.Oldest.ID, b.Newest.ID)
+	}
+	centerTweet(b, b.Newest)
+	if b.CenterTweet.ID != 20 || b.NewerCnt != 0 || b.OlderCnt != 2 {
+		t.Errorf("Centered on %d, with %d newer and %d older", b.CenterTweet.ID, b.NewerCnt, b.OlderCnt)
+	}
+	// Now the oldest goes, which is the one inserted
+	if InsertTweet(b, &TweetInfo{ID: 1}) || b.Oldest.ID != 5 {
+		t.Errorf("Inserting the farthest tweet from the center kept %d to %d", b.Oldest.ID, b.Newest.ID)
+	}
+}